@@ -44,6 +44,8 @@ type Account struct {
 	CertificateKey *rsa.PrivateKey
 	CertificateURL string
 	Domain         string
+	Issuer         string
+	CA             []byte
 }
 
 type ACMEClient struct {
@@ -51,18 +53,18 @@ type ACMEClient struct {
 }
 
 func newACMEClient(discoveryURL string, key *rsa.PrivateKey) (*ACMEClient, error) {
-	acmeClient := acme.Client{
+	acmeClient := &ACMEClient{acme.Client{
 		HTTPClient:   &httpClient,
 		Key:          key,
 		DirectoryURL: discoveryURL,
-	}
+	}}
 
 	_, err := acmeClient.Discover(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	return &ACMEClient{acmeClient}, nil
+	return acmeClient, nil
 }
 
 func (c *ACMEClient) Register(account *acme.Account) (*acme.Account, error) {
@@ -111,11 +113,8 @@ func (c *ACMEClient) Accept(authorization *acme.Authorization, challenge *acme.C
 	return nil
 }
 
-func (c *ACMEClient) CreateCert(domain string, key *rsa.PrivateKey) ([]byte, string, error) {
-	req := &x509.CertificateRequest{
-		Subject: pkix.Name{CommonName: domain},
-	}
-	csr, err := x509.CreateCertificateRequest(rand.Reader, req, key)
+func (c *ACMEClient) CreateCert(domain string, altNames []string, key *rsa.PrivateKey) ([]byte, string, error) {
+	csr, err := newCSR(domain, altNames, key)
 	if err != nil {
 		return nil, "", err
 	}
@@ -154,6 +153,24 @@ func (c *ACMEClient) RenewCert(certURL string) ([]byte, error) {
 	return pemEncodedCert, nil
 }
 
+// RevokeCert revokes the leaf certificate of the PEM encoded chain using the
+// account key.
+func (c *ACMEClient) RevokeCert(chain []byte) error {
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return errors.New("no PEM certificate found")
+	}
+	return c.Client.RevokeCert(context.Background(), nil, block.Bytes, acme.CRLReasonCessationOfOperation)
+}
+
+func newCSR(domain string, altNames []string, key *rsa.PrivateKey) ([]byte, error) {
+	req := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: append([]string{domain}, altNames...),
+	}
+	return x509.CreateCertificateRequest(rand.Reader, req, key)
+}
+
 func newAccount(email, domain string) (*Account, error) {
 	var account *Account
 
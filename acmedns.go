@@ -0,0 +1,177 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	dnsProviders["acme-dns"] = newACMEDNSProvider
+}
+
+// acmeDNSConfig is the provider secret format of the acme-dns DNS provider.
+type acmeDNSConfig struct {
+	APIURL        string   `json:"apiURL"`
+	AllowFrom     []string `json:"allowFrom"`
+	AccountSecret string   `json:"accountSecret"`
+}
+
+// acmeDNSAccount holds the credentials of an acme-dns account. Accounts are
+// stored in the account secret keyed by Certificate domain.
+type acmeDNSAccount struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	FullDomain string `json:"fulldomain"`
+	SubDomain  string `json:"subdomain"`
+}
+
+// acmeDNSProvider manages challenge records using the acme-dns HTTP API.
+// Each Certificate domain is registered as a separate acme-dns account and
+// its _acme-challenge record must be delegated to the account using a CNAME.
+type acmeDNSProvider struct {
+	config    acmeDNSConfig
+	client    *http.Client
+	domain    string
+	namespace string
+}
+
+func newACMEDNSProvider(config []byte, c *dnsClient) (dnsProvider, error) {
+	var ac acmeDNSConfig
+	err := json.Unmarshal(config, &ac)
+	if err != nil {
+		return nil, errors.New("Error decoding acme-dns config: " + err.Error())
+	}
+	if ac.APIURL == "" {
+		return nil, errors.New("acme-dns config requires an apiURL")
+	}
+	if ac.AccountSecret == "" {
+		ac.AccountSecret = "acme-dns-accounts"
+	}
+	return &acmeDNSProvider{ac, &httpClient, c.domain, c.namespace}, nil
+}
+
+func (p *acmeDNSProvider) createRecord(zone, fqdn, value string, ttl int) error {
+	account, err := p.account()
+	if err != nil {
+		return err
+	}
+
+	// The challenge record is only served by acme-dns once the CNAME exists
+	// and is followed, so that propagation is checked against acme-dns.
+	if !strings.EqualFold(fqdn, dns.Fqdn(account.FullDomain)) {
+		return fmt.Errorf("Create the CNAME record _acme-challenge.%s. -> %s and set spec.followCNAME",
+			p.domain, dns.Fqdn(account.FullDomain))
+	}
+
+	body := map[string]string{"subdomain": account.SubDomain, "txt": value}
+	headers := map[string]string{"X-Api-User": account.Username, "X-Api-Key": account.Password}
+	return p.do("/update", headers, body, nil)
+}
+
+// deleteRecord is a no-op as acme-dns keeps only the two most recent TXT
+// records of an account.
+func (p *acmeDNSProvider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	return nil
+}
+
+// account returns the acme-dns account of the Certificate domain, registering
+// a new account and storing it in the account secret if there is none.
+func (p *acmeDNSProvider) account() (*acmeDNSAccount, error) {
+	secret, err := getSecret(p.config.AccountSecret, p.namespace)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		if _, ok := secret.Data[p.domain]; ok {
+			data, err := getSecretValue(p.config.AccountSecret, p.namespace, p.domain)
+			if err != nil {
+				return nil, err
+			}
+			var account acmeDNSAccount
+			err = json.Unmarshal(data, &account)
+			if err != nil {
+				return nil, errors.New("Error decoding acme-dns account: " + err.Error())
+			}
+			return &account, nil
+		}
+	}
+
+	var body interface{}
+	if len(p.config.AllowFrom) > 0 {
+		body = map[string][]string{"allowfrom": p.config.AllowFrom}
+	}
+	var account acmeDNSAccount
+	err = p.do("/register", nil, body, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(account)
+	if err != nil {
+		return nil, err
+	}
+	err = setSecretValue(p.config.AccountSecret, p.namespace, p.domain, data)
+	if err != nil {
+		return nil, errors.New("Error storing acme-dns account: " + err.Error())
+	}
+	log.Printf("Registered acme-dns account for %s. Create the CNAME record _acme-challenge.%s. -> %s",
+		p.domain, p.domain, dns.Fqdn(account.FullDomain))
+	return &account, nil
+}
+
+func (p *acmeDNSProvider) do(path string, headers map[string]string, body, v interface{}) error {
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	if body != nil {
+		err := json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(p.config.APIURL, "/")+path, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("acme-dns %s failed: %s", path, e.Error)
+		}
+		return fmt.Errorf("acme-dns %s failed: %s", path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
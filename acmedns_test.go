@@ -0,0 +1,148 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// acmeDNSServer is a stand-in for the acme-dns HTTP API registering a single
+// account and recording the TXT values it is updated with.
+type acmeDNSServer struct {
+	*httptest.Server
+	mu            sync.Mutex
+	registrations []map[string][]string
+	updates       []map[string]string
+}
+
+func newACMEDNSServer(t *testing.T) *acmeDNSServer {
+	s := &acmeDNSServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/register":
+			var body map[string][]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decoding registration: %s", err)
+			}
+			s.registrations = append(s.registrations, body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"username":"user","password":"pass","fulldomain":"d420c923.auth.example.org","subdomain":"d420c923"}`))
+		case r.Method == "POST" && r.URL.Path == "/update":
+			if r.Header.Get("X-Api-User") != "user" || r.Header.Get("X-Api-Key") != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"forbidden"}`))
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decoding update: %s", err)
+			}
+			s.updates = append(s.updates, body)
+			w.Write([]byte(`{"txt":"` + body["txt"] + `"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"unexpected request"}`))
+		}
+	}))
+	return s
+}
+
+// newTestSecretsAPI points kubeAPI at a stand-in for the Kubernetes API
+// holding the Secrets of the default namespace.
+func newTestSecretsAPI(t *testing.T, secrets map[string]*Secret) *httptest.Server {
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		name := strings.TrimPrefix(r.URL.Path, secretsEndpoint("default"))
+		switch {
+		case r.Method == "GET" && secrets[strings.TrimPrefix(name, "/")] != nil:
+			json.NewEncoder(w).Encode(secrets[strings.TrimPrefix(name, "/")])
+		case r.Method == "POST" && name == "", r.Method == "PUT" && strings.HasPrefix(name, "/"):
+			var secret Secret
+			if err := json.NewDecoder(r.Body).Decode(&secret); err != nil {
+				t.Errorf("decoding secret: %s", err)
+			}
+			secrets[secret.Metadata.Name] = &secret
+			json.NewEncoder(w).Encode(secret)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","message":"not found"}`))
+		}
+	}))
+	kubeAPI = newProxyKubeClient()
+	kubeAPI.host = server.URL
+	return server
+}
+
+func TestACMEDNSCreateRecord(t *testing.T) {
+	s := newACMEDNSServer(t)
+	defer s.Close()
+	defer func(c *kubeClient) { kubeAPI = c }(kubeAPI)
+	secrets := make(map[string]*Secret)
+	kube := newTestSecretsAPI(t, secrets)
+	defer kube.Close()
+
+	config := `{"apiURL":"` + s.URL + `/","allowFrom":["10.0.0.0/8"]}`
+	p, err := newACMEDNSProvider([]byte(config), &dnsClient{domain: "www.example.com", namespace: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The account is registered and stored before the missing CNAME is
+	// reported.
+	err = p.createRecord("example.com.", "_acme-challenge.www.example.com.", "value", 60)
+	want := "Create the CNAME record _acme-challenge.www.example.com. -> d420c923.auth.example.org. and set spec.followCNAME"
+	if err == nil || err.Error() != want {
+		t.Errorf("createRecord error = %v, want %q", err, want)
+	}
+	if len(s.registrations) != 1 || !reflect.DeepEqual(s.registrations[0], map[string][]string{"allowfrom": {"10.0.0.0/8"}}) {
+		t.Errorf("registrations = %v, want one registration allowing 10.0.0.0/8", s.registrations)
+	}
+	secret := secrets["acme-dns-accounts"]
+	if secret == nil {
+		t.Fatal("account secret was not created")
+	}
+	data, _ := base64.StdEncoding.DecodeString(secret.Data["www.example.com"])
+	var account acmeDNSAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		t.Fatal(err)
+	}
+	if account != (acmeDNSAccount{"user", "pass", "d420c923.auth.example.org", "d420c923"}) {
+		t.Errorf("stored account = %+v, want the registered account", account)
+	}
+
+	// Once the CNAME is followed the stored account is updated.
+	if err := p.createRecord("auth.example.org.", "d420c923.auth.example.org.", "value", 60); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.registrations) != 1 {
+		t.Errorf("registrations = %v, want the stored account to be used", s.registrations)
+	}
+	if len(s.updates) != 1 || !reflect.DeepEqual(s.updates[0], map[string]string{"subdomain": "d420c923", "txt": "value"}) {
+		t.Errorf("updates = %v, want the challenge value for d420c923", s.updates)
+	}
+
+	secret.Data["www.example.com"] = base64.StdEncoding.EncodeToString([]byte(`{"username":"user","password":"wrong","fulldomain":"d420c923.auth.example.org","subdomain":"d420c923"}`))
+	err = p.createRecord("auth.example.org.", "d420c923.auth.example.org.", "value", 60)
+	if err == nil || err.Error() != "acme-dns /update failed: forbidden" {
+		t.Errorf("createRecord error = %v, want the acme-dns error", err)
+	}
+}
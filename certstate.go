@@ -0,0 +1,95 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+)
+
+const certificatesBucket = "Certificates"
+
+// CertificateState records the spec of a Certificate object the last time it
+// was processed, keyed by namespace and name, so spec changes can be
+// detected.
+type CertificateState struct {
+	Domain   string
+	Email    string
+	Issuer   string
+	SpecHash string
+}
+
+func certificateKey(c Certificate) []byte {
+	return []byte(c.Metadata.Namespace + "/" + c.Metadata.Name)
+}
+
+// specHash returns the SHA-256 hash of the JSON encoding of the Certificate
+// spec.
+func specHash(c Certificate) (string, error) {
+	data, err := json.Marshal(c.Spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func issuerType(c Certificate) string {
+	if c.Spec.Issuer == nil || c.Spec.Issuer.Type == "" {
+		return "acme"
+	}
+	return c.Spec.Issuer.Type
+}
+
+func findCertificateState(c Certificate, db *bolt.DB) (*CertificateState, error) {
+	var state *CertificateState
+	err := db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(certificatesBucket)).Get(certificateKey(c))
+		if data == nil {
+			return nil
+		}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&state)
+	})
+	return state, err
+}
+
+func saveCertificateState(c Certificate, db *bolt.DB) error {
+	hash, err := specHash(c)
+	if err != nil {
+		return err
+	}
+	state := &CertificateState{
+		Domain:   c.Spec.Domain,
+		Email:    c.Spec.Email,
+		Issuer:   issuerType(c),
+		SpecHash: hash,
+	}
+
+	data := new(bytes.Buffer)
+	err = gob.NewEncoder(data).Encode(state)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(certificatesBucket)).Put(certificateKey(c), data.Bytes())
+	})
+}
+
+func deleteCertificateState(c Certificate, db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(certificatesBucket)).Delete(certificateKey(c))
+	})
+}
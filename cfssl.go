@@ -0,0 +1,157 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type cfsslIssuer struct {
+	client    *http.Client
+	spec      CFSSLIssuerSpec
+	domain    string
+	hosts     []string
+	namespace string
+}
+
+type cfsslSignRequest struct {
+	Hosts   []string `json:"hosts"`
+	Request string   `json:"certificate_request"`
+	Profile string   `json:"profile,omitempty"`
+	Label   string   `json:"label,omitempty"`
+}
+
+type cfsslAuthRequest struct {
+	Token   []byte `json:"token"`
+	Request []byte `json:"request"`
+}
+
+type cfsslResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		Certificate string `json:"certificate"`
+	} `json:"result"`
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newCFSSLIssuer(c Certificate) (*cfsslIssuer, error) {
+	spec := c.Spec.Issuer.CFSSL
+	if spec == nil || spec.Address == "" || spec.AuthKeySecret == "" {
+		return nil, fmt.Errorf("cfssl issuer for %s requires an address and an authKeySecret", c.Metadata.Name)
+	}
+
+	client, err := newHTTPClient(spec.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfsslIssuer{client, *spec, c.Spec.Domain, certificateDomains(c), c.Metadata.Namespace}, nil
+}
+
+func (c *cfsslIssuer) Sign(csr []byte) (*IssuedCertificate, error) {
+	key, err := c.authKey()
+	if err != nil {
+		return nil, errors.New("Error getting cfssl auth key: " + err.Error())
+	}
+
+	request, err := json.Marshal(cfsslSignRequest{
+		// The hosts replace the SANs of the csr, so they include the alt
+		// names.
+		Hosts:   c.hosts,
+		Request: string(csr),
+		Profile: c.spec.Profile,
+		Label:   c.spec.Label,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The authsign token is an HMAC-SHA256 of the sign request using the
+	// auth key of the profile in ca-config.json.
+	mac := hmac.New(sha256.New, key)
+	mac.Write(request)
+
+	resp, err := c.do("authsign", cfsslAuthRequest{Token: mac.Sum(nil), Request: request})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Result.Certificate == "" {
+		return nil, errors.New("cfssl returned no certificate")
+	}
+
+	issued := &IssuedCertificate{Certificate: []byte(resp.Result.Certificate)}
+
+	// The certificate has been issued, so it is returned without the CA
+	// rather than signing a new one during the next reconciliation.
+	info, err := c.do("info", map[string]string{"label": c.spec.Label, "profile": c.spec.Profile})
+	if err != nil {
+		log.Printf("Error getting cfssl CA certificate for %s: %s", c.domain, err)
+		return issued, nil
+	}
+	if info.Result.Certificate != "" {
+		issued.CA = []byte(info.Result.Certificate)
+	}
+	return issued, nil
+}
+
+// authKey returns the hex decoded auth key stored in the configured Secret.
+func (c *cfsslIssuer) authKey() ([]byte, error) {
+	secretKey := c.spec.AuthKeySecretKey
+	if secretKey == "" {
+		secretKey = "key"
+	}
+	key, err := getSecretValue(c.spec.AuthKeySecret, c.namespace, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(key)))
+}
+
+func (c *cfsslIssuer) do(endpoint string, body interface{}) (*cfsslResponse, error) {
+	b := make([]byte, 0)
+	buf := bytes.NewBuffer(b)
+	err := json.NewEncoder(buf).Encode(body)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimRight(c.spec.Address, "/") + "/api/v1/cfssl/" + endpoint
+	resp, err := c.client.Post(url, "application/json", buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var cr cfsslResponse
+	err = json.NewDecoder(resp.Body).Decode(&cr)
+	if err != nil && resp.StatusCode == 200 {
+		return nil, err
+	}
+	if resp.StatusCode != 200 || !cr.Success {
+		if len(cr.Errors) > 0 {
+			return nil, fmt.Errorf("cfssl %s failed: %d %s", endpoint, cr.Errors[0].Code, cr.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("cfssl %s failed: %s", endpoint, resp.Status)
+	}
+	return &cr, nil
+}
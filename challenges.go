@@ -0,0 +1,154 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	challengeRecordsBucket = "ChallengeRecords"
+	challengeJanitorPeriod = time.Minute
+	maxCleanupBackoff      = time.Hour
+)
+
+// ChallengeRecord is a DNS-01 challenge record created by the controller. It
+// is kept in the ChallengeRecords bucket until the record has been deleted
+// from the DNS provider.
+type ChallengeRecord struct {
+	Owner       string
+	Domain      string
+	Provider    string
+	Secret      string
+	SecretKey   string
+	Credentials *CredentialsSpec
+	Namespace   string
+	Zone        string
+	FQDN        string
+	Value       string
+	TTL         int
+	Created     time.Time
+	Attempts    int
+	NextAttempt time.Time
+}
+
+func (r *ChallengeRecord) key() []byte {
+	return []byte(r.FQDN + " " + r.Value)
+}
+
+func (r *ChallengeRecord) dnsClient() *dnsClient {
+	return &dnsClient{
+		domain:          r.Domain,
+		provider:        r.Provider,
+		secret:          r.Secret,
+		secretKey:       r.SecretKey,
+		namespace:       r.Namespace,
+		zone:            r.Zone,
+		credentialsSpec: r.Credentials,
+	}
+}
+
+func saveChallengeRecord(r *ChallengeRecord, db *bolt.DB) error {
+	data := new(bytes.Buffer)
+	err := gob.NewEncoder(data).Encode(r)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(challengeRecordsBucket)).Put(r.key(), data.Bytes())
+	})
+}
+
+func deleteChallengeRecord(r *ChallengeRecord, db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(challengeRecordsBucket)).Delete(r.key())
+	})
+}
+
+func findChallengeRecords(db *bolt.DB) ([]*ChallengeRecord, error) {
+	records := make([]*ChallengeRecord, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(challengeRecordsBucket)).ForEach(func(k, v []byte) error {
+			var r ChallengeRecord
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&r)
+			if err != nil {
+				return err
+			}
+			records = append(records, &r)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// cleanupChallengeRecord deletes the challenge record from the DNS provider
+// and removes it from the ledger. If the delete fails the next attempt is
+// scheduled with exponential back-off.
+func cleanupChallengeRecord(r *ChallengeRecord, db *bolt.DB) error {
+	err := r.dnsClient().deleteRecord(r.FQDN, r.Value, r.TTL)
+	if err != nil {
+		r.Attempts++
+		backoff := challengeJanitorPeriod << uint(r.Attempts-1)
+		if backoff > maxCleanupBackoff || backoff <= 0 {
+			backoff = maxCleanupBackoff
+		}
+		r.NextAttempt = time.Now().Add(backoff)
+		if saveErr := saveChallengeRecord(r, db); saveErr != nil {
+			log.Println(saveErr)
+		}
+		return err
+	}
+	return deleteChallengeRecord(r, db)
+}
+
+// sweepChallengeRecords cleans up challenge records left behind by failed
+// deletes. When all is true every record in the ledger is cleaned up, which
+// is only safe while no Certificates are being processed.
+func sweepChallengeRecords(db *bolt.DB, all bool) {
+	records, err := findChallengeRecords(db)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, r := range records {
+		if !all && (r.Attempts == 0 || time.Now().Before(r.NextAttempt)) {
+			continue
+		}
+		log.Printf("Cleaning up %s challenge record %s for %s", r.Provider, r.FQDN, r.Owner)
+		err := cleanupChallengeRecord(r, db)
+		if err != nil {
+			log.Printf("Error cleaning up challenge record %s, retrying at %s: %s",
+				r.FQDN, r.NextAttempt.Format(time.RFC3339), err)
+		}
+	}
+}
+
+// runChallengeJanitor retries failed challenge record cleanups.
+func runChallengeJanitor(db *bolt.DB, done chan struct{}, wg *sync.WaitGroup) {
+	go func() {
+		for {
+			select {
+			case <-time.After(challengeJanitorPeriod):
+				sweepChallengeRecords(db, false)
+			case <-done:
+				wg.Done()
+				log.Println("Stopped challenge record janitor.")
+				return
+			}
+		}
+	}()
+}
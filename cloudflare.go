@@ -0,0 +1,191 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	dnsProviders["cloudflare"] = newCloudflareProvider
+}
+
+// cloudflareConfig is the provider secret format of the cloudflare DNS
+// provider. Either an API token or an email and global API key is required.
+type cloudflareConfig struct {
+	APIToken string `json:"apiToken"`
+	Email    string `json:"email"`
+	APIKey   string `json:"apiKey"`
+	Endpoint string `json:"endpoint"`
+}
+
+// cloudflareProvider manages challenge records using the Cloudflare v4 API.
+type cloudflareProvider struct {
+	config  cloudflareConfig
+	client  *http.Client
+	baseURL string
+}
+
+type cloudflareRecord struct {
+	Id      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type cloudflareResponse struct {
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newCloudflareProvider(config []byte, _ *dnsClient) (dnsProvider, error) {
+	var c cloudflareConfig
+	err := json.Unmarshal(config, &c)
+	if err != nil {
+		return nil, errors.New("Error decoding cloudflare config: " + err.Error())
+	}
+	if c.APIToken == "" && (c.Email == "" || c.APIKey == "") {
+		return nil, errors.New("cloudflare config requires an apiToken or an email and apiKey")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://api.cloudflare.com/client/v4"
+	}
+	return &cloudflareProvider{c, &httpClient, strings.TrimRight(c.Endpoint, "/")}, nil
+}
+
+func (p *cloudflareProvider) createRecord(zone, fqdn, value string, ttl int) error {
+	zoneID, err := p.zoneID(zone, fqdn)
+	if err != nil {
+		return err
+	}
+
+	// Cloudflare rejects TTLs below 120 seconds.
+	if ttl < 120 {
+		ttl = 120
+	}
+	record := cloudflareRecord{
+		Type:    "TXT",
+		Name:    strings.TrimSuffix(fqdn, "."),
+		Content: value,
+		TTL:     ttl,
+	}
+	return p.do("POST", "/zones/"+zoneID+"/dns_records", nil, record, nil)
+}
+
+func (p *cloudflareProvider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	zoneID, err := p.zoneID(zone, fqdn)
+	if err != nil {
+		return err
+	}
+
+	var records []cloudflareRecord
+	query := url.Values{
+		"type":    {"TXT"},
+		"name":    {strings.TrimSuffix(fqdn, ".")},
+		"content": {value},
+	}
+	err = p.do("GET", "/zones/"+zoneID+"/dns_records", query, nil, &records)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		err := p.do("DELETE", "/zones/"+zoneID+"/dns_records/"+record.Id, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zoneID returns the ID of the zone with the longest name containing zone,
+// or fqdn if the zone is unknown.
+func (p *cloudflareProvider) zoneID(zone, fqdn string) (string, error) {
+	if zone == "" {
+		zone = fqdn
+	}
+	for _, i := range dns.Split(zone) {
+		var zones []struct {
+			Id string `json:"id"`
+		}
+		query := url.Values{"name": {strings.TrimSuffix(zone[i:], ".")}}
+		err := p.do("GET", "/zones", query, nil, &zones)
+		if err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].Id, nil
+		}
+	}
+	return "", fmt.Errorf("No Cloudflare zone found for %s", fqdn)
+}
+
+func (p *cloudflareProvider) do(method, path string, query url.Values, body, v interface{}) error {
+	u := p.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	if body != nil {
+		err := json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, u, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.config.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.APIToken)
+	} else {
+		req.Header.Set("X-Auth-Email", p.config.Email)
+		req.Header.Set("X-Auth-Key", p.config.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var cr cloudflareResponse
+	err = json.NewDecoder(resp.Body).Decode(&cr)
+	if err != nil {
+		return fmt.Errorf("Cloudflare %s failed: %s", path, resp.Status)
+	}
+	if !cr.Success {
+		if len(cr.Errors) > 0 {
+			return fmt.Errorf("Cloudflare %s failed: %d %s", path, cr.Errors[0].Code, cr.Errors[0].Message)
+		}
+		return fmt.Errorf("Cloudflare %s failed: %s", path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(cr.Result, v)
+}
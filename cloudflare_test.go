@@ -0,0 +1,148 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cloudflareServer is a stand-in for the Cloudflare v4 API holding the TXT
+// records of the zones it serves.
+type cloudflareServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	zones   map[string]string
+	records map[string]cloudflareRecord
+	nextID  int
+}
+
+func newCloudflareServer(t *testing.T, zones map[string]string) *cloudflareServer {
+	s := &cloudflareServer{zones: zones, records: make(map[string]cloudflareRecord)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("%s %s: unexpected Authorization %q", r.Method, r.URL, r.Header.Get("Authorization"))
+		}
+
+		path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.Method == "GET" && len(path) == 1 && path[0] == "zones":
+			zones := make([]map[string]string, 0)
+			if id, ok := s.zones[r.URL.Query().Get("name")]; ok {
+				zones = append(zones, map[string]string{"id": id})
+			}
+			s.reply(w, zones)
+		case r.Method == "POST" && len(path) == 3 && path[2] == "dns_records":
+			var record cloudflareRecord
+			if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+				t.Errorf("decoding record: %s", err)
+			}
+			s.nextID++
+			record.Id = fmt.Sprintf("%s-%d", path[1], s.nextID)
+			s.records[record.Id] = record
+			s.reply(w, record)
+		case r.Method == "GET" && len(path) == 3 && path[2] == "dns_records":
+			query := r.URL.Query()
+			records := make([]cloudflareRecord, 0)
+			for _, record := range s.records {
+				if strings.HasPrefix(record.Id, path[1]+"-") && record.Type == query.Get("type") &&
+					record.Name == query.Get("name") && record.Content == query.Get("content") {
+					records = append(records, record)
+				}
+			}
+			s.reply(w, records)
+		case r.Method == "DELETE" && len(path) == 4 && path[2] == "dns_records":
+			delete(s.records, path[3])
+			s.reply(w, map[string]string{"id": path[3]})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"success":false,"errors":[{"code":7003,"message":"unexpected request"}]}`))
+		}
+	}))
+	return s
+}
+
+func (s *cloudflareServer) reply(w http.ResponseWriter, result interface{}) {
+	data, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(cloudflareResponse{Success: true, Result: data})
+}
+
+func newTestCloudflareProvider(t *testing.T, endpoint string) dnsProvider {
+	p, err := newCloudflareProvider([]byte(`{"apiToken":"token","endpoint":"`+endpoint+`"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCloudflareCreateDeleteRecord(t *testing.T) {
+	s := newCloudflareServer(t, map[string]string{"example.com": "z1"})
+	defer s.Close()
+	p := newTestCloudflareProvider(t, s.URL)
+
+	fqdn := "_acme-challenge.www.example.com."
+	if err := p.createRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.createRecord("example.com.", fqdn, "second", 300); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.records) != 2 {
+		t.Fatalf("records = %+v, want two records", s.records)
+	}
+	for _, record := range s.records {
+		if !strings.HasPrefix(record.Id, "z1-") || record.Type != "TXT" || record.Name != "_acme-challenge.www.example.com" {
+			t.Errorf("record = %+v, want a TXT record for %s in zone z1", record, fqdn)
+		}
+		if record.Content == "first" && record.TTL != 120 {
+			t.Errorf("record TTL = %d, want the 120 second minimum", record.TTL)
+		}
+		if record.Content == "second" && record.TTL != 300 {
+			t.Errorf("record TTL = %d, want 300", record.TTL)
+		}
+	}
+
+	if err := p.deleteRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.records) != 1 {
+		t.Fatalf("records = %+v, want one record", s.records)
+	}
+	for _, record := range s.records {
+		if record.Content != "second" {
+			t.Errorf("remaining record = %+v, want the second record", record)
+		}
+	}
+}
+
+func TestCloudflareErrors(t *testing.T) {
+	s := newCloudflareServer(t, map[string]string{})
+	defer s.Close()
+
+	p := newTestCloudflareProvider(t, s.URL)
+	err := p.createRecord("", "_acme-challenge.example.com.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "No Cloudflare zone found") {
+		t.Errorf("createRecord error = %v, want no zone found", err)
+	}
+
+	p = newTestCloudflareProvider(t, s.URL+"/invalid")
+	err = p.createRecord("", "_acme-challenge.example.com.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "7003 unexpected request") {
+		t.Errorf("createRecord error = %v, want the Cloudflare error message", err)
+	}
+}
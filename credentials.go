@@ -0,0 +1,209 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// credentialsNamespace is the namespace holding the Secrets referenced by the
+// credentials in the provider options, set by the -credentials-namespace flag.
+var credentialsNamespace = ""
+
+// secretCache holds the Secrets read for DNS provider credentials. Entries
+// are invalidated by the Secret watches of their namespaces.
+var secretCache = &secretStore{
+	secrets:     make(map[string]*Secret),
+	generations: make(map[string]uint64),
+	watched:     make(map[string]bool),
+}
+
+// dnsCredentials are the provider config and additional exec plugin
+// environment of a DNS provider.
+type dnsCredentials struct {
+	config []byte
+	env    []string
+}
+
+// secretStore caches Secrets by namespace and name. The generation of a key
+// is bumped whenever its entry is invalidated, so a Secret fetched while the
+// entry was invalidated is not stored.
+type secretStore struct {
+	mu          sync.Mutex
+	secrets     map[string]*Secret
+	generations map[string]uint64
+	watched     map[string]bool
+}
+
+func (s *secretStore) get(name, namespace string) (*Secret, error) {
+	key := namespace + "/" + name
+	s.mu.Lock()
+	secret, ok := s.secrets[key]
+	generation, tracked := s.generations[key]
+	if !tracked {
+		s.generations[key] = generation
+	}
+	watched := s.watched[namespace]
+	s.watched[namespace] = true
+	s.mu.Unlock()
+	if ok {
+		return secret, nil
+	}
+	// The watch lists the namespace before watching it, which invalidates
+	// any Secret fetched before the watch started.
+	if !watched {
+		watchSecrets(namespace)
+	}
+
+	secret, err := getSecret(name, namespace)
+	if err != nil || secret == nil {
+		return secret, err
+	}
+	s.mu.Lock()
+	if s.generations[key] == generation {
+		s.secrets[key] = secret
+	}
+	s.mu.Unlock()
+	return secret, nil
+}
+
+func (s *secretStore) invalidate(name, namespace string) {
+	key := namespace + "/" + name
+	s.mu.Lock()
+	if _, ok := s.generations[key]; ok {
+		s.generations[key]++
+	}
+	delete(s.secrets, key)
+	s.mu.Unlock()
+}
+
+// clear invalidates every Secret of the namespace.
+func (s *secretStore) clear(namespace string) {
+	s.mu.Lock()
+	for key := range s.generations {
+		if strings.HasPrefix(key, namespace+"/") {
+			s.generations[key]++
+			delete(s.secrets, key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// watchSecrets invalidates cached Secrets of the namespace when they are
+// modified or deleted. Only the namespaces Secrets are read from are
+// watched. The cached Secrets of the namespace are cleared when the watch
+// fails, as changes may be missed.
+func watchSecrets(namespace string) {
+	events, errc := resumableWatch("/api/v1/namespaces/"+namespace+"/secrets", "")
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				var secret Secret
+				err := json.Unmarshal(event.Object, &secret)
+				if err != nil {
+					log.Println("Error decoding secret event: " + err.Error())
+					secretCache.clear(namespace)
+					continue
+				}
+				secretCache.invalidate(secret.Metadata.Name, secret.Metadata.Namespace)
+			case err := <-errc:
+				log.Printf("Error watching secrets in %s: %s", namespace, err)
+				secretCache.clear(namespace)
+			}
+		}
+	}()
+}
+
+// credentials returns the credentials of the DNS provider. Credentials set on
+// the Certificate are read from the Certificate namespace. Otherwise the
+// credentials in the provider options are used, read from the credentials
+// namespace or from a file mounted into the controller.
+func (c *dnsClient) credentials() (*dnsCredentials, error) {
+	spec := c.credentialsSpec
+	if spec == nil && c.secret != "" {
+		spec = &CredentialsSpec{Secret: c.secret, Key: c.secretKey}
+	}
+	if spec != nil {
+		if spec.File != "" {
+			return nil, errors.New("Credential files can only be set in the provider options")
+		}
+		return readCredentials(spec, c.namespace)
+	}
+
+	spec = dnsProviderOptions[c.provider].Credentials
+	if spec == nil {
+		return &dnsCredentials{}, nil
+	}
+	if spec.Secret != "" && credentialsNamespace == "" {
+		return nil, fmt.Errorf("%s credentials reference a secret but -credentials-namespace is not set", c.provider)
+	}
+	return readCredentials(spec, credentialsNamespace)
+}
+
+func readCredentials(spec *CredentialsSpec, namespace string) (*dnsCredentials, error) {
+	credentials := &dnsCredentials{}
+	if spec.File != "" {
+		data, err := ioutil.ReadFile(spec.File)
+		if err != nil {
+			return nil, errors.New("Error reading credentials file: " + err.Error())
+		}
+		credentials.config = data
+	}
+	if spec.Secret == "" {
+		return credentials, nil
+	}
+
+	secret, err := secretCache.get(spec.Secret, namespace)
+	if err != nil {
+		return nil, errors.New("Error getting dns config from secret: " + err.Error())
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("Secret %s/%s not found", namespace, spec.Secret)
+	}
+
+	if spec.Key != "" {
+		credentials.config, err = secretData(secret, spec.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0)
+	for name := range spec.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := secretData(secret, spec.Env[name])
+		if err != nil {
+			return nil, err
+		}
+		credentials.env = append(credentials.env, envVar(name, string(value)))
+	}
+	return credentials, nil
+}
+
+func secretData(secret *Secret, key string) ([]byte, error) {
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("Secret key %s not found in %s", key, secret.Metadata.Name)
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// A Secret fetched while its cache entry is invalidated must not be cached,
+// as it may predate the change that caused the invalidation.
+func TestSecretStoreInvalidateDuringGet(t *testing.T) {
+	var fetches int32
+	fetching, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/secrets/dns" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			fetching <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(`{"metadata":{"name":"dns","namespace":"default"},"data":{"key":"dmFsdWU="}}`))
+	}))
+	defer server.Close()
+	defer func(c *kubeClient) { kubeAPI = c }(kubeAPI)
+	kubeAPI = newProxyKubeClient()
+	kubeAPI.host = server.URL
+
+	// The namespace is marked as watched so no watch is started.
+	s := &secretStore{
+		secrets:     make(map[string]*Secret),
+		generations: make(map[string]uint64),
+		watched:     map[string]bool{"default": true},
+	}
+	done := make(chan error)
+	go func() {
+		_, err := s.get("dns", "default")
+		done <- err
+	}()
+	<-fetching
+	s.invalidate("dns", "default")
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.secrets["default/dns"]; ok {
+		t.Fatal("secret fetched before the invalidation was cached")
+	}
+
+	for i := 0; i < 2; i++ {
+		secret, err := s.get("dns", "default")
+		if err != nil || secret == nil {
+			t.Fatalf("get = %v, %v, want the secret", secret, err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+
+	s.clear("default")
+	if _, ok := s.secrets["default/dns"]; ok {
+		t.Error("secret cached after the namespace was cleared")
+	}
+}
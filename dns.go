@@ -11,15 +11,12 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
 	"net"
-	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -28,15 +25,50 @@ import (
 )
 
 type dnsClient struct {
-	domain    string
-	provider  string
-	secret    string
-	secretKey string
-	namespace string
+	domain      string
+	provider    string
+	secret      string
+	secretKey   string
+	namespace   string
+	zone        string
+	propagation propagationCheck
+
+	credentialsSpec *CredentialsSpec
+}
+
+// dnsProvider creates and deletes DNS-01 challenge TXT records.
+type dnsProvider interface {
+	createRecord(zone, fqdn, value string, ttl int) error
+	deleteRecord(zone, fqdn, value string, ttl int) error
+}
+
+// dnsProviders holds the built-in DNS providers by name. Each provider is
+// created from the configuration stored in the Certificate's provider secret
+// and the dnsClient of the Certificate. Providers not registered here are run
+// as dns-01 exec plugins.
+var dnsProviders = make(map[string]func(config []byte, c *dnsClient) (dnsProvider, error))
+
+// checkDNSProvider returns an error if name is neither a built-in DNS provider
+// nor an available dns-01 exec plugin.
+func checkDNSProvider(name string) error {
+	if _, ok := dnsProviders[name]; ok {
+		return nil
+	}
+	if _, err := findPlugin(name); err != nil {
+		return fmt.Errorf("Unknown DNS provider %q: %s", name, err)
+	}
+	return nil
 }
 
 func newDNSClient(provider, domain, secret, secretKey, namespace string) (*dnsClient, error) {
-	return &dnsClient{domain, provider, secret, secretKey, namespace}, nil
+	return &dnsClient{
+		domain:      domain,
+		provider:    provider,
+		secret:      secret,
+		secretKey:   secretKey,
+		namespace:   namespace,
+		propagation: propagationSettings(provider, nil),
+	}, nil
 }
 
 func envVar(key, value string) string {
@@ -44,87 +76,64 @@ func envVar(key, value string) string {
 }
 
 func (c *dnsClient) createRecord(fqdn, value string, ttl int) error {
-	providerConfig, err := getDNSConfigFromSecret(c.secret, c.namespace, c.secretKey)
+	credentials, err := c.credentials()
 	if err != nil {
-		return errors.New("Error getting dns config from secret" + err.Error())
-	}
-	env := []string{
-		envVar("APIVERSION", "v1"),
-		envVar("COMMAND", "CREATE"),
-		envVar("DOMAIN", c.domain),
-		envVar("FQDN", fqdn),
-		envVar("TOKEN", value),
-	}
-
-	cmd := &exec.Cmd{
-		Path:  filepath.Join("/", c.provider),
-		Env:   env,
-		Stdin: bytes.NewReader(providerConfig),
+		return err
 	}
-	_, err = cmd.Output()
-	if err != nil {
-		exitError, ok := err.(*exec.ExitError)
-		if ok {
-			return errors.New(string(exitError.Stderr))
+	if newProvider, ok := dnsProviders[c.provider]; ok {
+		provider, err := newProvider(credentials.config, c)
+		if err != nil {
+			return err
 		}
-		return err
+		return provider.createRecord(c.zone, fqdn, value, ttl)
 	}
-	return nil
+	return c.runPlugin("CREATE", fqdn, value, ttl, credentials)
 }
 
 func (c *dnsClient) deleteRecord(fqdn, value string, ttl int) error {
-	providerConfig, err := getDNSConfigFromSecret(c.secret, c.namespace, c.secretKey)
+	credentials, err := c.credentials()
 	if err != nil {
-		return errors.New("Error getting dns config from secret" + err.Error())
-	}
-	env := []string{
-		envVar("APIVERSION", "v1"),
-		envVar("COMMAND", "DELETE"),
-		envVar("DOMAIN", c.domain),
-		envVar("FQDN", fqdn),
-		envVar("TOKEN", value),
-	}
-
-	cmd := &exec.Cmd{
-		Path:  filepath.Join("/", c.provider),
-		Env:   env,
-		Stdin: bytes.NewReader(providerConfig),
+		return err
 	}
-	_, err = cmd.Output()
-	if err != nil {
-		exitError, ok := err.(*exec.ExitError)
-		if ok {
-			return errors.New(string(exitError.Stderr))
+	if newProvider, ok := dnsProviders[c.provider]; ok {
+		provider, err := newProvider(credentials.config, c)
+		if err != nil {
+			return err
 		}
-		return err
+		return provider.deleteRecord(c.zone, fqdn, value, ttl)
 	}
-	return nil
+	return c.runPlugin("DELETE", fqdn, value, ttl, credentials)
 }
 
 func (c *dnsClient) monitorDNSPropagation(fqdn, value string, ttl int) error {
+	propagation := c.propagation
+	if propagation.skip {
+		log.Printf("Skipping %s DNS propagation check", fqdn)
+		time.Sleep(propagation.extraWait)
+		return nil
+	}
+	if propagation.transport != "tcp" && propagation.transport != "udp" {
+		return fmt.Errorf("Invalid DNS propagation transport %q", propagation.transport)
+	}
+
 	dnsClient := new(dns.Client)
-	dnsClient.Net = "tcp"
+	dnsClient.Net = propagation.transport
 	dnsClient.Timeout = time.Second * 10
-	delimiter := "."
-	domainSplited := strings.Split(c.domain, delimiter)
-
-	var ns []*net.NS
 
-	for i := range domainSplited {
+	zone := c.zone
+	if zone == "" {
 		var err error
-		domain := strings.Join((domainSplited)[i:], delimiter)
-		ns, err = net.LookupNS(domain)
-		if err == nil {
-			break
-		} else {
-			log.Println("No NS found for:", domain)
-			log.Println(err)
+		zone, err = findZone(fqdn)
+		if err != nil {
+			return err
 		}
 	}
-
-	nameservers := make([]string, 0)
-	for _, s := range ns {
-		nameservers = append(nameservers, net.JoinHostPort(s.Host, "53"))
+	nameservers, err := authoritativeNameservers(zone)
+	if err != nil {
+		return err
+	}
+	if len(nameservers) == 0 {
+		return fmt.Errorf("No authoritative nameservers found for %s", zone)
 	}
 
 	log.Printf("Monitoring %s DNS propagation: %s", fqdn, strings.Join(nameservers, " "))
@@ -134,6 +143,19 @@ func (c *dnsClient) monitorDNSPropagation(fqdn, value string, ttl int) error {
 	dnsMsg.SetEdns0(4096, false)
 	dnsMsg.RecursionDesired = false
 
+	// The nameservers are polled until the record propagated or stop is
+	// closed on return.
+	stop := make(chan struct{})
+	defer close(stop)
+	poll := func() bool {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(propagation.pollInterval):
+			return true
+		}
+	}
+
 	var wg sync.WaitGroup
 	for _, ns := range nameservers {
 		wg.Add(1)
@@ -141,25 +163,25 @@ func (c *dnsClient) monitorDNSPropagation(fqdn, value string, ttl int) error {
 			defer wg.Done()
 			for {
 				in, _, err := dnsClient.Exchange(dnsMsg, ns)
+				if err == nil && in.Truncated {
+					tcpClient := &dns.Client{Net: "tcp", Timeout: dnsClient.Timeout}
+					in, _, err = tcpClient.Exchange(dnsMsg, ns)
+				}
 				if err != nil {
 					log.Println(err)
-					time.Sleep(1 * time.Second)
-					continue
-				}
-
-				if len(in.Answer) == 0 {
-					time.Sleep(1 * time.Second)
-					continue
-				}
-
-				for _, rr := range in.Answer {
-					if txt, ok := rr.(*dns.TXT); ok {
-						if strings.Join(txt.Txt, "") == value {
-							log.Printf("%s DNS-01 challenge complete on %s", c.domain, ns)
-							return
+				} else {
+					for _, rr := range in.Answer {
+						if txt, ok := rr.(*dns.TXT); ok {
+							if strings.Join(txt.Txt, "") == value {
+								log.Printf("%s DNS-01 challenge complete on %s", c.domain, ns)
+								return
+							}
 						}
 					}
 				}
+				if !poll() {
+					return
+				}
 			}
 		}(ns)
 	}
@@ -174,14 +196,193 @@ func (c *dnsClient) monitorDNSPropagation(fqdn, value string, ttl int) error {
 	case <-done:
 		// Wait until the TTL expires to be sure Let's Encrypt picks up the
 		// right TXT record.
-		time.Sleep(time.Duration(ttl) * time.Second)
+		time.Sleep(time.Duration(ttl)*time.Second + propagation.extraWait)
 		log.Printf("%s DNS propagation complete.", fqdn)
 		return nil
-	case <-time.After(300 * time.Second):
+	case <-time.After(propagation.timeout):
 		return fmt.Errorf("Timeout waiting for %s DNS propagation", fqdn)
 	}
 }
 
+// propagationCheck holds the resolved propagation check settings of a
+// challenge record.
+type propagationCheck struct {
+	skip         bool
+	timeout      time.Duration
+	pollInterval time.Duration
+	transport    string
+	extraWait    time.Duration
+}
+
+// propagationSettings returns the propagation check settings of the
+// Certificate spec merged with the settings of the provider and the
+// defaults. Settings on the Certificate take precedence.
+func propagationSettings(provider string, spec *PropagationSpec) propagationCheck {
+	// Records served by the embedded DNS server are available immediately.
+	settings := propagationCheck{
+		skip:         provider == embeddedDNSProvider,
+		timeout:      300 * time.Second,
+		pollInterval: time.Second,
+		transport:    "tcp",
+	}
+	options := dnsProviderOptions[provider]
+	for _, s := range []*PropagationSpec{&options.Propagation, spec} {
+		if s == nil {
+			continue
+		}
+		if s.Skip != nil {
+			settings.skip = *s.Skip
+		}
+		if s.Timeout.Duration > 0 {
+			settings.timeout = s.Timeout.Duration
+		}
+		if s.PollInterval.Duration > 0 {
+			settings.pollInterval = s.PollInterval.Duration
+		}
+		if s.Transport != "" {
+			settings.transport = s.Transport
+		}
+		if s.ExtraWait != nil {
+			settings.extraWait = s.ExtraWait.Duration
+		}
+	}
+	return settings
+}
+
+// maxCNAMEChain is the maximum number of CNAME records followed when
+// resolving the target of a challenge record.
+const maxCNAMEChain = 10
+
+// dnsResolvers is a comma separated list of recursive nameservers used for
+// DNS lookups. The nameservers in /etc/resolv.conf are used when empty.
+var dnsResolvers = ""
+
+// recursiveNameservers returns the recursive nameservers used for DNS
+// lookups.
+func recursiveNameservers() ([]string, error) {
+	nameservers := make([]string, 0)
+	if dnsResolvers != "" {
+		for _, server := range strings.Split(dnsResolvers, ",") {
+			server = strings.TrimSpace(server)
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			nameservers = append(nameservers, server)
+		}
+		return nameservers, nil
+	}
+
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range config.Servers {
+		nameservers = append(nameservers, net.JoinHostPort(server, config.Port))
+	}
+	return nameservers, nil
+}
+
+// recursiveQuery sends a recursive query for name to each recursive
+// nameserver in turn until one of them answers.
+func recursiveQuery(name string, qtype uint16) (*dns.Msg, error) {
+	nameservers, err := recursiveNameservers()
+	if err != nil {
+		return nil, err
+	}
+	if len(nameservers) == 0 {
+		return nil, errors.New("No recursive nameservers configured")
+	}
+
+	client := new(dns.Client)
+	client.Timeout = time.Second * 10
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	for _, ns := range nameservers {
+		var in *dns.Msg
+		in, _, err = client.Exchange(m, ns)
+		if err == nil && in.Truncated {
+			client.Net = "tcp"
+			in, _, err = client.Exchange(m, ns)
+			client.Net = ""
+		}
+		if err == nil {
+			return in, nil
+		}
+		log.Printf("Error querying %s for %s: %s", ns, name, err)
+	}
+	return nil, err
+}
+
+// resolveCNAME follows the CNAME chain starting at fqdn and returns the name
+// at the end of the chain. fqdn is returned if it is not a CNAME.
+func resolveCNAME(fqdn string) (string, error) {
+	name := fqdn
+	for i := 0; i < maxCNAMEChain; i++ {
+		in, err := recursiveQuery(name, dns.TypeCNAME)
+		if err != nil {
+			return "", err
+		}
+
+		var target string
+		for _, rr := range in.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				target = cname.Target
+			}
+		}
+		if target == "" {
+			return name, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("CNAME chain for %s is longer than %d records", fqdn, maxCNAMEChain)
+}
+
+// findZone returns the apex of the zone containing fqdn by querying the SOA
+// record of fqdn and each of its parent domains.
+func findZone(fqdn string) (string, error) {
+	for _, i := range dns.Split(fqdn) {
+		name := fqdn[i:]
+		in, err := recursiveQuery(name, dns.TypeSOA)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range in.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, name) {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("No zone found for %s", fqdn)
+}
+
+// authoritativeNameservers returns the addresses of the nameservers listed
+// in the NS records of zone.
+func authoritativeNameservers(zone string) ([]string, error) {
+	in, err := recursiveQuery(zone, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	nameservers := make([]string, 0)
+	for _, rr := range in.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			nameservers = append(nameservers, net.JoinHostPort(ns.Ns, "53"))
+		}
+	}
+	return nameservers, nil
+}
+
+// matchZone returns the longest of zones that contains fqdn.
+func matchZone(fqdn string, zones []string) (string, bool) {
+	var match string
+	for _, zone := range zones {
+		if dns.IsSubDomain(dns.Fqdn(zone), fqdn) && len(zone) > len(match) {
+			match = zone
+		}
+	}
+	return match, match != ""
+}
+
 func DNSChallengeRecord(domain, token, jwkThumbprint string) (string, string, int) {
 	fqdn := fmt.Sprintf("_acme-challenge.%s.", domain)
 	keyAuthorization := fmt.Sprintf("%s.%s", token, jwkThumbprint)
@@ -0,0 +1,77 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMatchZone(t *testing.T) {
+	zones := []string{"example.com.", "sub.example.com", "example.org."}
+	tests := []struct {
+		fqdn  string
+		match string
+		ok    bool
+	}{
+		{"_acme-challenge.example.com.", "example.com.", true},
+		{"example.com.", "example.com.", true},
+		{"_acme-challenge.www.sub.example.com.", "sub.example.com", true},
+		{"sub.example.com.", "sub.example.com", true},
+		{"_acme-challenge.notsub.example.com.", "example.com.", true},
+		{"_acme-challenge.example.org.", "example.org.", true},
+		{"_acme-challenge.example.net.", "", false},
+		{"_acme-challenge.anexample.com.", "", false},
+	}
+	for _, test := range tests {
+		match, ok := matchZone(test.fqdn, zones)
+		if match != test.match || ok != test.ok {
+			t.Errorf("matchZone(%q) = %q, %v, want %q, %v", test.fqdn, match, ok, test.match, test.ok)
+		}
+	}
+}
+
+func TestPropagationSettings(t *testing.T) {
+	defer func(options map[string]DNSProviderOptions) { dnsProviderOptions = options }(dnsProviderOptions)
+	dnsProviderOptions = map[string]DNSProviderOptions{}
+	err := json.Unmarshal([]byte(`{"rfc2136": {"propagation": {"skip": true, "extraWait": "30s", "timeout": "15m"}}}`), &dnsProviderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		provider string
+		spec     string
+		want     propagationCheck
+	}{
+		{"googledns", ``, propagationCheck{false, 300 * time.Second, time.Second, "tcp", 0}},
+		{"embedded", ``, propagationCheck{true, 300 * time.Second, time.Second, "tcp", 0}},
+		{"embedded", `{"skip": false}`, propagationCheck{false, 300 * time.Second, time.Second, "tcp", 0}},
+		{"rfc2136", ``, propagationCheck{true, 15 * time.Minute, time.Second, "tcp", 30 * time.Second}},
+		{"rfc2136", `{}`, propagationCheck{true, 15 * time.Minute, time.Second, "tcp", 30 * time.Second}},
+		{"rfc2136", `{"skip": false, "extraWait": "0s"}`, propagationCheck{false, 15 * time.Minute, time.Second, "tcp", 0}},
+		{"rfc2136", `{"timeout": "1m", "pollInterval": "5s", "transport": "udp"}`, propagationCheck{true, time.Minute, 5 * time.Second, "udp", 30 * time.Second}},
+	}
+	for _, test := range tests {
+		var spec *PropagationSpec
+		if test.spec != "" {
+			spec = new(PropagationSpec)
+			if err := json.Unmarshal([]byte(test.spec), spec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := propagationSettings(test.provider, spec)
+		if got != test.want {
+			t.Errorf("propagationSettings(%q, %s) = %+v, want %+v", test.provider, test.spec, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// dnsPluginRetries is the number of times a v2 plugin command is attempted
+// when the plugin reports a retryable error.
+var dnsPluginRetries = 3
+
+var (
+	pluginVersionsMu sync.Mutex
+	pluginVersions   = make(map[string]string)
+)
+
+// dnsProviderOptions holds the per provider settings loaded from the file
+// named by the -dns-provider-config flag.
+var dnsProviderOptions = make(map[string]DNSProviderOptions)
+
+// DNSProviderOptions configures how a DNS provider is run.
+type DNSProviderOptions struct {
+	// Timeout is the deadline for a single plugin command.
+	Timeout duration `json:"timeout"`
+	// Env holds additional environment variables for the plugin.
+	Env map[string]string `json:"env"`
+	// PassEnv lists controller environment variables passed through to
+	// the plugin.
+	PassEnv []string `json:"passEnv"`
+	// Credentials are used by Certificates that do not set credentials of
+	// their own.
+	Credentials *CredentialsSpec `json:"credentials"`
+	// Propagation configures the propagation check of challenge records
+	// created by the provider.
+	Propagation PropagationSpec `json:"propagation"`
+}
+
+// duration is a time.Duration that is decoded from a JSON string such as
+// "90s".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func loadDNSProviderOptions(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &dnsProviderOptions)
+}
+
+// pluginEnv returns env extended with the environment configured for the
+// provider.
+func pluginEnv(provider string, env []string) []string {
+	options := dnsProviderOptions[provider]
+	for key, value := range options.Env {
+		env = append(env, envVar(key, value))
+	}
+	for _, key := range options.PassEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, envVar(key, value))
+		}
+	}
+	return env
+}
+
+func providerTimeout(provider string) time.Duration {
+	if timeout := dnsProviderOptions[provider].Timeout.Duration; timeout > 0 {
+		return timeout
+	}
+	return pluginTimeout
+}
+
+// dnsPluginRequest is written to the stdin of v2 dns-01 exec plugins.
+type dnsPluginRequest struct {
+	APIVersion string            `json:"apiVersion"`
+	Command    string            `json:"command"`
+	Domain     string            `json:"domain"`
+	Zone       string            `json:"zone,omitempty"`
+	Records    []dnsPluginRecord `json:"records"`
+	Config     []byte            `json:"config"`
+}
+
+type dnsPluginRecord struct {
+	FQDN  string `json:"fqdn"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   int    `json:"ttl"`
+}
+
+// dnsPluginResponse is read from the stdout of v2 dns-01 exec plugins.
+type dnsPluginResponse struct {
+	Error *dnsPluginError `json:"error,omitempty"`
+}
+
+type dnsPluginError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (e *dnsPluginError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// dnsPluginCapabilities is returned by v2 plugins for the CAPABILITIES
+// command.
+type dnsPluginCapabilities struct {
+	APIVersions []string `json:"apiVersions"`
+	Commands    []string `json:"commands"`
+}
+
+func (c *dnsClient) runPlugin(command, fqdn, value string, ttl int, credentials *dnsCredentials) error {
+	path, err := findPlugin(c.provider)
+	if err != nil {
+		return err
+	}
+	if pluginVersion(c.provider, path) != "v2" {
+		env := pluginEnv(c.provider, []string{
+			envVar("APIVERSION", "v1"),
+			envVar("COMMAND", command),
+			envVar("DOMAIN", c.domain),
+			envVar("FQDN", fqdn),
+			envVar("TOKEN", value),
+			envVar("ZONE", c.zone),
+		})
+		env = append(env, credentials.env...)
+		_, err := execPlugin(path, env, credentials.config, providerTimeout(c.provider))
+		return err
+	}
+
+	request := dnsPluginRequest{
+		APIVersion: "v2",
+		Command:    command,
+		Domain:     c.domain,
+		Zone:       c.zone,
+		Records:    []dnsPluginRecord{{FQDN: fqdn, Type: "TXT", Value: value, TTL: ttl}},
+		Config:     credentials.config,
+	}
+	for attempt := 1; attempt <= dnsPluginRetries; attempt++ {
+		err = runPluginV2(c.provider, path, request, credentials.env)
+		pluginErr, ok := err.(*dnsPluginError)
+		if !ok || !pluginErr.Retryable {
+			return err
+		}
+		log.Printf("%s %s %s failed, retrying: %s", c.provider, command, fqdn, err)
+		time.Sleep(time.Duration(attempt*2) * time.Second)
+	}
+	return err
+}
+
+// pluginVersion negotiates the protocol version of the plugin at path by
+// running the CAPABILITIES command. Plugins that do not support the command
+// are assumed to implement v1. The version is cached unless the probe timed
+// out or the plugin failed to start, in which case the next call probes
+// again.
+func pluginVersion(provider, path string) string {
+	pluginVersionsMu.Lock()
+	version, ok := pluginVersions[path]
+	pluginVersionsMu.Unlock()
+	if ok {
+		return version
+	}
+
+	version, ok = probePluginVersion(provider, path)
+	if !ok {
+		return version
+	}
+	log.Printf("Using %s protocol for dns-01 exec plugin %s", version, path)
+	pluginVersionsMu.Lock()
+	pluginVersions[path] = version
+	pluginVersionsMu.Unlock()
+	return version
+}
+
+// probePluginVersion runs the CAPABILITIES command of the plugin at path.
+// It returns false if the plugin gave no definitive answer because it timed
+// out or failed to start. Plugins that exit with a non-zero status or print
+// anything but a JSON response implement v1.
+func probePluginVersion(provider, path string) (string, bool) {
+	env := pluginEnv(provider, []string{
+		envVar("APIVERSION", "v2"),
+		envVar("COMMAND", "CAPABILITIES"),
+	})
+	out, err := execPlugin(path, env, nil, providerTimeout(provider))
+	if err != nil {
+		if pluginErr, ok := err.(*pluginError); ok {
+			if exitErr, ok := pluginErr.Err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+				return "v1", true
+			}
+		}
+		log.Printf("Error probing the protocol version of dns-01 exec plugin %s, using v1: %s", path, err)
+		return "v1", false
+	}
+
+	var capabilities dnsPluginCapabilities
+	if json.Unmarshal(out, &capabilities) != nil {
+		return "v1", true
+	}
+	for _, v := range capabilities.APIVersions {
+		if v == "v2" {
+			return "v2", true
+		}
+	}
+	return "v1", true
+}
+
+func runPluginV2(provider, path string, request dnsPluginRequest, credentialsEnv []string) error {
+	stdin, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	env := pluginEnv(provider, []string{
+		envVar("APIVERSION", "v2"),
+		envVar("COMMAND", request.Command),
+	})
+	env = append(env, credentialsEnv...)
+	stdout, err := execPlugin(path, env, stdin, providerTimeout(provider))
+
+	var response dnsPluginResponse
+	if len(bytes.TrimSpace(stdout)) > 0 {
+		if jsonErr := json.Unmarshal(stdout, &response); jsonErr != nil && err == nil {
+			return errors.New("Error decoding dns plugin response: " + jsonErr.Error())
+		}
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	return err
+}
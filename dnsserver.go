@@ -0,0 +1,178 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// embeddedDNSProvider is the name of the DNS provider that serves challenge
+// records from the embedded DNS server.
+const embeddedDNSProvider = "embedded"
+
+var (
+	dnsServerAddr       = ":53"
+	dnsServerZone       = ""
+	dnsServerNameserver = ""
+)
+
+// challengeRecords holds the TXT records served by the embedded DNS server.
+var challengeRecords = &challengeStore{records: make(map[string][]string)}
+
+func init() {
+	dnsProviders[embeddedDNSProvider] = newEmbeddedProvider
+}
+
+type challengeStore struct {
+	mu      sync.Mutex
+	records map[string][]string
+}
+
+func (s *challengeStore) add(fqdn, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fqdn = strings.ToLower(fqdn)
+	for _, v := range s.records[fqdn] {
+		if v == value {
+			return
+		}
+	}
+	s.records[fqdn] = append(s.records[fqdn], value)
+}
+
+func (s *challengeStore) remove(fqdn, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fqdn = strings.ToLower(fqdn)
+	values := make([]string, 0)
+	for _, v := range s.records[fqdn] {
+		if v != value {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		delete(s.records, fqdn)
+		return
+	}
+	s.records[fqdn] = values
+}
+
+func (s *challengeStore) get(fqdn string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.records[strings.ToLower(fqdn)]...)
+}
+
+// embeddedProvider manages challenge records served by the embedded DNS
+// server. It requires no provider secret.
+type embeddedProvider struct {
+	domain string
+}
+
+func newEmbeddedProvider(config []byte, c *dnsClient) (dnsProvider, error) {
+	if dnsServerZone == "" {
+		return nil, errors.New("The embedded DNS server is not enabled, set the -dns-server-zone flag")
+	}
+	return &embeddedProvider{c.domain}, nil
+}
+
+func (p *embeddedProvider) createRecord(zone, fqdn, value string, ttl int) error {
+	if !dns.IsSubDomain(dnsServerZone, fqdn) {
+		return fmt.Errorf("Create the CNAME record _acme-challenge.%s. -> %s%s and set spec.followCNAME",
+			p.domain, dns.Fqdn(p.domain), dnsServerZone)
+	}
+	challengeRecords.add(fqdn, value)
+	return nil
+}
+
+func (p *embeddedProvider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	challengeRecords.remove(fqdn, value)
+	return nil
+}
+
+// serveDNS starts the embedded authoritative DNS server for dnsServerZone on
+// dnsServerAddr, over both UDP and TCP.
+func serveDNS() {
+	dnsServerZone = dns.Fqdn(strings.ToLower(dnsServerZone))
+	if dnsServerNameserver == "" {
+		dnsServerNameserver = dnsServerZone
+	}
+	dnsServerNameserver = dns.Fqdn(dnsServerNameserver)
+
+	handler := dns.HandlerFunc(handleDNSRequest)
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: dnsServerAddr, Net: network, Handler: handler}
+		go func(server *dns.Server) {
+			log.Fatal(server.ListenAndServe())
+		}(server)
+	}
+	log.Printf("Serving the %s zone on %s", dnsServerZone, dnsServerAddr)
+}
+
+func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	if len(r.Question) != 1 || !dns.IsSubDomain(dnsServerZone, r.Question[0].Name) {
+		m.SetRcode(r, dns.RcodeRefused)
+		m.Authoritative = false
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	values := challengeRecords.get(name)
+
+	switch {
+	case name == dnsServerZone && q.Qtype == dns.TypeSOA:
+		m.Answer = append(m.Answer, dnsServerSOA())
+	case name == dnsServerZone && q.Qtype == dns.TypeNS:
+		m.Answer = append(m.Answer, &dns.NS{
+			Hdr: dns.RR_Header{Name: dnsServerZone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 300},
+			Ns:  dnsServerNameserver,
+		})
+	case len(values) > 0 && q.Qtype == dns.TypeTXT:
+		for _, value := range values {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: []string{value},
+			})
+		}
+	case len(values) > 0 || name == dnsServerZone:
+		m.Ns = append(m.Ns, dnsServerSOA())
+	default:
+		m.SetRcode(r, dns.RcodeNameError)
+		m.Authoritative = true
+		m.Ns = append(m.Ns, dnsServerSOA())
+	}
+	w.WriteMsg(m)
+}
+
+func dnsServerSOA() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dnsServerZone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300},
+		Ns:      dnsServerNameserver,
+		Mbox:    "hostmaster." + dnsServerZone,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  0,
+	}
+}
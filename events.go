@@ -0,0 +1,176 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	eventTypeNormal  = "Normal"
+	eventTypeWarning = "Warning"
+
+	// eventAggregationWindow is how long an event is updated, instead of
+	// recording a new one, when it repeats. It matches the default event
+	// TTL of the API server.
+	eventAggregationWindow = time.Hour
+)
+
+type Event struct {
+	ApiVersion     string          `json:"apiVersion"`
+	Kind           string          `json:"kind"`
+	Metadata       Metadata        `json:"metadata"`
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Source         EventSource     `json:"source"`
+	FirstTimestamp string          `json:"firstTimestamp"`
+	LastTimestamp  string          `json:"lastTimestamp"`
+	Count          int             `json:"count"`
+}
+
+type ObjectReference struct {
+	ApiVersion      string `json:"apiVersion"`
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	UID             string `json:"uid,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type EventSource struct {
+	Component string `json:"component"`
+}
+
+// recorder records Kubernetes Events against Certificate objects.
+var recorder = &eventRecorder{recorded: make(map[string]*recordedEvent)}
+
+// eventRecorder aggregates repeated identical events by incrementing the
+// count of the event recorded first.
+type eventRecorder struct {
+	mu       sync.Mutex
+	recorded map[string]*recordedEvent
+}
+
+type recordedEvent struct {
+	event    *Event
+	lastSeen time.Time
+}
+
+func (r *eventRecorder) normal(c Certificate, reason, format string, args ...interface{}) {
+	r.record(c, eventTypeNormal, reason, fmt.Sprintf(format, args...))
+}
+
+func (r *eventRecorder) warning(c Certificate, reason, format string, args ...interface{}) {
+	r.record(c, eventTypeWarning, reason, fmt.Sprintf(format, args...))
+}
+
+// record creates an event for the Certificate, or updates the count of an
+// identical event recorded within the aggregation window. Errors are logged
+// as events are informational. The API server is called without holding the
+// lock, as its requests are rate limited.
+func (r *eventRecorder) record(c Certificate, eventType, reason, message string) {
+	now := time.Now()
+	timestamp := now.UTC().Format(time.RFC3339)
+	key := fmt.Sprintf("%s/%s/%s/%s/%s/%s", c.Metadata.Namespace, c.Metadata.Name, c.Metadata.UID, eventType, reason, message)
+
+	r.mu.Lock()
+	for k, recorded := range r.recorded {
+		if now.Sub(recorded.lastSeen) > eventAggregationWindow {
+			delete(r.recorded, k)
+		}
+	}
+	recorded, ok := r.recorded[key]
+	r.mu.Unlock()
+
+	if ok {
+		event := *recorded.event
+		event.Count++
+		event.LastTimestamp = timestamp
+		var updated Event
+		err := kubeAPI.do("PUT", eventEndpoint(c.Metadata.Namespace, event.Metadata.Name), &event, &updated)
+		if err == nil {
+			r.store(key, recorded, &recordedEvent{&updated, now})
+			return
+		}
+		// The event expired or was changed by someone else, so a new
+		// event is recorded instead.
+	}
+
+	event := &Event{
+		ApiVersion: "v1",
+		Kind:       "Event",
+		Metadata: Metadata{
+			Name:      fmt.Sprintf("%s.%x", c.Metadata.Name, now.UnixNano()),
+			Namespace: c.Metadata.Namespace,
+		},
+		InvolvedObject: ObjectReference{
+			ApiVersion:      c.ApiVersion,
+			Kind:            "Certificate",
+			Name:            c.Metadata.Name,
+			Namespace:       c.Metadata.Namespace,
+			UID:             c.Metadata.UID,
+			ResourceVersion: c.Metadata.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         EventSource{Component: "kube-cert-manager"},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+	var created Event
+	err := kubeAPI.do("POST", eventsEndpoint(c.Metadata.Namespace), event, &created)
+	if err != nil {
+		log.Printf("Error recording %s event for certificate %s/%s: %s", reason, c.Metadata.Namespace, c.Metadata.Name, err)
+		r.store(key, recorded, nil)
+		return
+	}
+	r.store(key, recorded, &recordedEvent{&created, now})
+}
+
+// store replaces the recorded event of key, unless it was replaced by
+// another call since previous was read. A nil event removes the entry.
+func (r *eventRecorder) store(key string, previous, recorded *recordedEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recorded[key] != previous {
+		return
+	}
+	if recorded == nil {
+		delete(r.recorded, key)
+		return
+	}
+	r.recorded[key] = recorded
+}
+
+func eventsEndpoint(namespace string) string {
+	return "/api/v1/namespaces/" + namespace + "/events"
+}
+
+func eventEndpoint(namespace, name string) string {
+	return eventsEndpoint(namespace) + "/" + name
+}
+
+// describeCertificate returns the serial number and expiry of the leaf
+// certificate in the PEM encoded chain.
+func describeCertificate(chain []byte) string {
+	cert := parseLeafCertificate(chain)
+	if cert == nil {
+		return "unable to parse certificate"
+	}
+	return fmt.Sprintf("serial %x, valid until %s", cert.SerialNumber, cert.NotAfter.UTC().Format(time.RFC3339))
+}
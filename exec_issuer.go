@@ -0,0 +1,113 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// execIssuer signs certificates using an exec based issuer plugin. The plugin
+// receives an execIssuerRequest on stdin and writes an execIssuerResponse to
+// stdout.
+type execIssuer struct {
+	spec      ExecIssuerSpec
+	domain    string
+	namespace string
+}
+
+type execIssuerRequest struct {
+	Domain       string            `json:"domain"`
+	CSR          string            `json:"csr"`
+	Config       map[string]string `json:"config,omitempty"`
+	SecretConfig []byte            `json:"secretConfig,omitempty"`
+}
+
+type execIssuerResponse struct {
+	Certificate string           `json:"certificate"`
+	CA          string           `json:"ca"`
+	Error       *execIssuerError `json:"error"`
+}
+
+type execIssuerError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newExecIssuer(c Certificate) (*execIssuer, error) {
+	spec := c.Spec.Issuer.Exec
+	if spec == nil || spec.Plugin == "" {
+		return nil, fmt.Errorf("exec issuer for %s requires a plugin", c.Metadata.Name)
+	}
+	if spec.Secret != "" && spec.SecretKey == "" {
+		return nil, fmt.Errorf("exec issuer for %s requires a secretKey when secret is set", c.Metadata.Name)
+	}
+	return &execIssuer{*spec, c.Spec.Domain, c.Metadata.Namespace}, nil
+}
+
+func (e *execIssuer) Sign(csr []byte) (*IssuedCertificate, error) {
+	request := execIssuerRequest{
+		Domain: e.domain,
+		CSR:    string(csr),
+		Config: e.spec.Config,
+	}
+	if e.spec.Secret != "" {
+		secretConfig, err := getSecretValue(e.spec.Secret, e.namespace, e.spec.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting issuer config from secret: %v", err)
+		}
+		request.SecretConfig = secretConfig
+	}
+	stdin, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		envVar("APIVERSION", "v1"),
+		envVar("COMMAND", "SIGN"),
+		envVar("DOMAIN", e.domain),
+	}
+
+	path, err := findPlugin(e.spec.Plugin)
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := execPlugin(path, env, stdin, pluginTimeout)
+
+	var response execIssuerResponse
+	if len(bytes.TrimSpace(stdout)) > 0 {
+		if jsonErr := json.Unmarshal(stdout, &response); jsonErr != nil && err == nil {
+			return nil, errors.New("Error decoding issuer plugin response: " + jsonErr.Error())
+		}
+	}
+
+	if response.Error != nil {
+		if response.Error.Code == "Pending" {
+			return nil, ErrIssuancePending
+		}
+		return nil, fmt.Errorf("%s: %s", response.Error.Code, response.Error.Message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.Certificate == "" {
+		return nil, errors.New("Issuer plugin returned no certificate")
+	}
+
+	issued := &IssuedCertificate{Certificate: []byte(response.Certificate)}
+	if response.CA != "" {
+		issued.CA = []byte(response.CA)
+	}
+	return issued, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// certificateFinalizer is added to every managed Certificate so that the
+// API server keeps deleted Certificates until they are cleaned up, even if
+// the controller is not running when they are deleted.
+const certificateFinalizer = "stable.hightower.com/kube-cert-manager"
+
+func hasFinalizer(c Certificate) bool {
+	for _, f := range c.Metadata.Finalizers {
+		if f == certificateFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer adds the finalizer to the Certificate if it is missing.
+func addFinalizer(c Certificate) error {
+	if hasFinalizer(c) {
+		return nil
+	}
+	current, err := getCertificate(c.Metadata.Namespace, c.Metadata.Name)
+	if err != nil || current == nil || hasFinalizer(*current) {
+		return err
+	}
+	finalizers := append(current.Metadata.Finalizers, certificateFinalizer)
+	err = setCertificateFinalizers(current, finalizers)
+	if err != nil {
+		return errors.New("Error adding finalizer: " + err.Error())
+	}
+	return nil
+}
+
+// removeFinalizer removes the finalizer from the Certificate, allowing the
+// API server to delete it.
+func removeFinalizer(c Certificate) error {
+	current, err := getCertificate(c.Metadata.Namespace, c.Metadata.Name)
+	if err != nil || current == nil || !hasFinalizer(*current) {
+		return err
+	}
+	finalizers := make([]string, 0)
+	for _, f := range current.Metadata.Finalizers {
+		if f != certificateFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	err = setCertificateFinalizers(current, finalizers)
+	if err != nil {
+		return errors.New("Error removing finalizer: " + err.Error())
+	}
+	return nil
+}
+
+// finalizeCertificate cleans up a deleted Certificate and removes its
+// finalizer. The finalizer is only kept if the TLS secret or the state of
+// the Certificate cannot be deleted, so that step is retried during the next
+// reconciliation.
+func finalizeCertificate(c Certificate, db *bolt.DB) error {
+	if !hasFinalizer(c) {
+		return nil
+	}
+	log.Printf("Finalizing deleted certificate %s/%s", c.Metadata.Namespace, c.Metadata.Name)
+
+	// Challenge records that cannot be deleted now stay in the ledger and
+	// are retried by the janitor.
+	records, err := findChallengeRecords(db)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if r.Owner != c.Metadata.Namespace+"/"+c.Metadata.Name {
+			continue
+		}
+		log.Printf("Cleaning up %s challenge record %s for %s", r.Provider, r.FQDN, r.Owner)
+		err := cleanupChallengeRecord(r, db)
+		if err != nil {
+			log.Printf("Error cleaning up challenge record %s, retrying at %s: %s",
+				r.FQDN, r.NextAttempt.Format(time.RFC3339), err)
+		}
+	}
+
+	// Deletion is always honoured, so revocation and issuer cleanup
+	// failures are reported and do not keep the finalizer.
+	if c.Spec.RevokeOnDelete {
+		err := revokeCertificate(c, db)
+		if err != nil {
+			cleanupFailed(c, "Error revoking certificate: "+err.Error())
+		}
+	}
+
+	if issuerType(c) == "manual" {
+		issuer, err := newManualIssuer(c)
+		if err == nil {
+			log.Printf("Deleting certificate signing request config map %s/%s", c.Metadata.Namespace, issuer.spec.ConfigMap)
+			err = deleteKubernetesConfigMap(c.Metadata.Namespace, issuer.spec.ConfigMap)
+		}
+		if err != nil {
+			cleanupFailed(c, "Error deleting certificate signing request config map: "+err.Error())
+		}
+	}
+	if issuerType(c) == "kubernetes" {
+		err := deleteCertificateSigningRequests(c)
+		if err != nil {
+			cleanupFailed(c, "Error deleting certificate signing requests: "+err.Error())
+		}
+	}
+
+	err = deleteCertificate(c, db)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	err = deleteCertificateState(c, db)
+	if err != nil {
+		return err
+	}
+	return removeFinalizer(c)
+}
+
+// cleanupFailed logs and records a warning for a cleanup step of a deleted
+// Certificate that is not retried.
+func cleanupFailed(c Certificate, message string) {
+	log.Printf("Error finalizing certificate %s/%s: %s", c.Metadata.Namespace, c.Metadata.Name, message)
+	recorder.warning(c, "CleanupFailed", "%s", message)
+}
+
+// revokeCertificate revokes the current certificate of an ACME Certificate.
+// Expired certificates and certificates of other issuers are not revoked.
+func revokeCertificate(c Certificate, db *bolt.DB) error {
+	account, err := findAccount(c.Spec.Domain, db)
+	if err != nil || account == nil || account.Certificate == nil {
+		return err
+	}
+	if issuerType(c) != "acme" {
+		log.Printf("Revocation is not supported by the %s issuer, skipping %s", issuerType(c), c.Spec.Domain)
+		return nil
+	}
+	cert := parseLeafCertificate(account.Certificate)
+	if cert == nil || time.Now().After(cert.NotAfter) {
+		return nil
+	}
+
+	acmeClient, err := newACMEClient(discoveryURL, account.AccountKey)
+	if err != nil {
+		return errors.New("Error creating ACME client: " + err.Error())
+	}
+	log.Printf("Revoking certificate %s serial %x", c.Spec.Domain, cert.SerialNumber)
+	return acmeClient.RevokeCert(account.Certificate)
+}
@@ -0,0 +1,304 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	dnsProviders["googleclouddns"] = newGoogleCloudDNSProvider
+}
+
+const googleCloudDNSScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+// googleServiceAccount is the provider secret format of the googleclouddns
+// DNS provider. It is the JSON key file of a Google Cloud service account,
+// optionally with the Cloud DNS API endpoint added.
+type googleServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	Endpoint    string `json:"endpoint"`
+}
+
+// googleCloudDNSProvider manages challenge records using the Google Cloud
+// DNS v1 API.
+type googleCloudDNSProvider struct {
+	account googleServiceAccount
+	key     *rsa.PrivateKey
+	client  *http.Client
+	baseURL string
+	token   string
+}
+
+type googleResourceRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+type googleChange struct {
+	Additions []googleResourceRecordSet `json:"additions,omitempty"`
+	Deletions []googleResourceRecordSet `json:"deletions,omitempty"`
+}
+
+func newGoogleCloudDNSProvider(config []byte, _ *dnsClient) (dnsProvider, error) {
+	var account googleServiceAccount
+	err := json.Unmarshal(config, &account)
+	if err != nil {
+		return nil, errors.New("Error decoding googleclouddns service account: " + err.Error())
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" {
+		return nil, errors.New("googleclouddns service account requires a project_id and client_email")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	if account.Endpoint == "" {
+		account.Endpoint = "https://dns.googleapis.com/dns/v1"
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("googleclouddns service account has no PEM encoded private_key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("googleclouddns service account private_key is not an RSA key")
+	}
+
+	return &googleCloudDNSProvider{
+		account: account,
+		key:     key,
+		client:  &httpClient,
+		baseURL: strings.TrimRight(account.Endpoint, "/") + "/projects/" + account.ProjectID,
+	}, nil
+}
+
+func (p *googleCloudDNSProvider) createRecord(zone, fqdn, value string, ttl int) error {
+	managedZone, current, err := p.recordSet(zone, fqdn)
+	if err != nil {
+		return err
+	}
+
+	rrset := googleResourceRecordSet{Name: fqdn, Type: "TXT", TTL: ttl, Rrdatas: []string{`"` + value + `"`}}
+	change := googleChange{}
+	if current != nil {
+		for _, rrdata := range current.Rrdatas {
+			if rrdata == rrset.Rrdatas[0] {
+				return nil
+			}
+		}
+		rrset.Rrdatas = append(rrset.Rrdatas, current.Rrdatas...)
+		change.Deletions = []googleResourceRecordSet{*current}
+	}
+	change.Additions = []googleResourceRecordSet{rrset}
+	return p.do("POST", "/managedZones/"+managedZone+"/changes", nil, change, nil)
+}
+
+func (p *googleCloudDNSProvider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	managedZone, current, err := p.recordSet(zone, fqdn)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+
+	remaining := make([]string, 0)
+	for _, rrdata := range current.Rrdatas {
+		if rrdata != `"`+value+`"` {
+			remaining = append(remaining, rrdata)
+		}
+	}
+	if len(remaining) == len(current.Rrdatas) {
+		return nil
+	}
+
+	change := googleChange{Deletions: []googleResourceRecordSet{*current}}
+	if len(remaining) > 0 {
+		rrset := *current
+		rrset.Rrdatas = remaining
+		change.Additions = []googleResourceRecordSet{rrset}
+	}
+	return p.do("POST", "/managedZones/"+managedZone+"/changes", nil, change, nil)
+}
+
+// recordSet returns the name of the managed zone containing zone, or fqdn if
+// the zone is unknown, and the existing TXT record set for fqdn, if any.
+func (p *googleCloudDNSProvider) recordSet(zone, fqdn string) (string, *googleResourceRecordSet, error) {
+	if zone == "" {
+		zone = fqdn
+	}
+
+	dnsNames := make([]string, 0)
+	names := make(map[string]string)
+	query := url.Values{}
+	for {
+		var zones struct {
+			ManagedZones []struct {
+				Name    string `json:"name"`
+				DNSName string `json:"dnsName"`
+			} `json:"managedZones"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := p.do("GET", "/managedZones", query, nil, &zones)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, zone := range zones.ManagedZones {
+			dnsNames = append(dnsNames, zone.DNSName)
+			names[zone.DNSName] = zone.Name
+		}
+		if zones.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", zones.NextPageToken)
+	}
+	dnsName, ok := matchZone(zone, dnsNames)
+	if !ok {
+		return "", nil, fmt.Errorf("No Google Cloud DNS managed zone found for %s", fqdn)
+	}
+	managedZone := names[dnsName]
+
+	var rrsets struct {
+		Rrsets []googleResourceRecordSet `json:"rrsets"`
+	}
+	query = url.Values{"name": {fqdn}, "type": {"TXT"}}
+	err := p.do("GET", "/managedZones/"+managedZone+"/rrsets", query, nil, &rrsets)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(rrsets.Rrsets) == 0 {
+		return managedZone, nil, nil
+	}
+	return managedZone, &rrsets.Rrsets[0], nil
+}
+
+// accessToken exchanges a service account JWT for an OAuth2 access token.
+func (p *googleCloudDNSProvider) accessToken() (string, error) {
+	if p.token != "" {
+		return p.token, nil
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   p.account.ClientEmail,
+		"scope": googleCloudDNSScope,
+		"aud":   p.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	resp, err := p.client.PostForm(p.account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("Google OAuth2 token request failed: " + resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	p.token = token.AccessToken
+	return p.token, nil
+}
+
+func (p *googleCloudDNSProvider) do(method, path string, query url.Values, body, v interface{}) error {
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+
+	u := p.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	if body != nil {
+		err := json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, u, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("Google Cloud DNS %s failed: %s", path, e.Error.Message)
+		}
+		return fmt.Errorf("Google Cloud DNS %s failed: %s", path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,212 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// googleCloudDNSServer is a stand-in for the Google OAuth2 token endpoint
+// and the Cloud DNS v1 API of the "project" project. Changes are applied
+// to the record sets of the managed zones, keyed by zone and name.
+type googleCloudDNSServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	zones   map[string]string
+	rrsets  map[string]googleResourceRecordSet
+	changes []googleChange
+}
+
+func newGoogleCloudDNSServer(t *testing.T, zones map[string]string) *googleCloudDNSServer {
+	s := &googleCloudDNSServer{zones: zones, rrsets: make(map[string]googleResourceRecordSet)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.URL.Path == "/token" {
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.FormValue("assertion"), ".") != 2 {
+				t.Errorf("unexpected token request %v", r.Form)
+			}
+			w.Write([]byte(`{"access_token":"token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("%s %s: unexpected Authorization %q", r.Method, r.URL, r.Header.Get("Authorization"))
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/dns/v1/projects/project/managedZones")
+		switch {
+		case r.Method == "GET" && path == "":
+			// Managed zones are listed one per page.
+			dnsNames := make([]string, 0)
+			for dnsName := range s.zones {
+				dnsNames = append(dnsNames, dnsName)
+			}
+			sort.Strings(dnsNames)
+			page, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+			var list struct {
+				ManagedZones  []map[string]string `json:"managedZones"`
+				NextPageToken string              `json:"nextPageToken,omitempty"`
+			}
+			if page < len(dnsNames) {
+				list.ManagedZones = append(list.ManagedZones, map[string]string{"name": s.zones[dnsNames[page]], "dnsName": dnsNames[page]})
+			}
+			if page+1 < len(dnsNames) {
+				list.NextPageToken = strconv.Itoa(page + 1)
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == "GET" && strings.HasSuffix(path, "/rrsets"):
+			zone := strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/rrsets")
+			var list struct {
+				Rrsets []googleResourceRecordSet `json:"rrsets"`
+			}
+			if rrset, ok := s.rrsets[zone+" "+r.URL.Query().Get("name")]; ok && r.URL.Query().Get("type") == "TXT" {
+				list.Rrsets = append(list.Rrsets, rrset)
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == "POST" && strings.HasSuffix(path, "/changes"):
+			zone := strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/changes")
+			var change googleChange
+			if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+				t.Errorf("decoding change: %s", err)
+			}
+			for _, rrset := range change.Deletions {
+				if !reflect.DeepEqual(s.rrsets[zone+" "+rrset.Name], rrset) {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					w.Write([]byte(`{"error":{"message":"deleted record set does not match"}}`))
+					return
+				}
+				delete(s.rrsets, zone+" "+rrset.Name)
+			}
+			for _, rrset := range change.Additions {
+				s.rrsets[zone+" "+rrset.Name] = rrset
+			}
+			s.changes = append(s.changes, change)
+			w.Write([]byte(`{"status":"pending"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"unexpected request"}}`))
+		}
+	}))
+	return s
+}
+
+func newTestGoogleCloudDNSProvider(t *testing.T, s *googleCloudDNSServer, endpoint string) dnsProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(googleServiceAccount{
+		ProjectID:   "project",
+		ClientEmail: "dns@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    s.URL + "/token",
+		Endpoint:    endpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newGoogleCloudDNSProvider(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGoogleCloudDNSCreateDeleteRecord(t *testing.T) {
+	s := newGoogleCloudDNSServer(t, map[string]string{"example.com.": "example", "sub.example.com.": "sub"})
+	defer s.Close()
+	p := newTestGoogleCloudDNSProvider(t, s, s.URL+"/dns/v1")
+
+	fqdn := "_acme-challenge.sub.example.com."
+	key := "sub " + fqdn
+	if err := p.createRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	want := googleResourceRecordSet{Name: fqdn, Type: "TXT", TTL: 30, Rrdatas: []string{`"first"`}}
+	if !reflect.DeepEqual(s.rrsets[key], want) {
+		t.Fatalf("record set = %+v, want %+v", s.rrsets[key], want)
+	}
+
+	// A second value is merged into the existing record set, and creating
+	// an existing value is a no-op.
+	if err := p.createRecord("", fqdn, "second", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.createRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	want.Rrdatas = []string{`"second"`, `"first"`}
+	if !reflect.DeepEqual(s.rrsets[key], want) {
+		t.Fatalf("record set = %+v, want %+v", s.rrsets[key], want)
+	}
+	if len(s.changes) != 2 {
+		t.Errorf("changes = %+v, want two changes", s.changes)
+	}
+
+	// Deleting a value keeps the other values of the record set, and the
+	// record set is removed with its last value.
+	if err := p.deleteRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	want.Rrdatas = []string{`"second"`}
+	if !reflect.DeepEqual(s.rrsets[key], want) {
+		t.Fatalf("record set = %+v, want %+v", s.rrsets[key], want)
+	}
+	if err := p.deleteRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.deleteRecord("", fqdn, "second", 30); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.rrsets) != 0 {
+		t.Errorf("record sets = %+v, want none", s.rrsets)
+	}
+	if len(s.changes) != 4 {
+		t.Errorf("changes = %+v, want four changes", s.changes)
+	}
+	last := s.changes[len(s.changes)-1]
+	if len(last.Additions) != 0 || len(last.Deletions) != 1 {
+		t.Errorf("last change = %+v, want only the deletion of the record set", last)
+	}
+}
+
+func TestGoogleCloudDNSErrors(t *testing.T) {
+	s := newGoogleCloudDNSServer(t, map[string]string{"example.com.": "example"})
+	defer s.Close()
+	p := newTestGoogleCloudDNSProvider(t, s, s.URL+"/dns/v1")
+
+	err := p.createRecord("", "_acme-challenge.example.org.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "No Google Cloud DNS managed zone found") {
+		t.Errorf("createRecord error = %v, want no managed zone found", err)
+	}
+
+	p = newTestGoogleCloudDNSProvider(t, s, s.URL+"/invalid")
+	err = p.createRecord("", "_acme-challenge.example.com.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("createRecord error = %v, want the Google Cloud DNS error message", err)
+	}
+}
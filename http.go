@@ -12,6 +12,9 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -36,3 +39,25 @@ func init() {
 		},
 	}
 }
+
+// newHTTPClient returns an http.Client that verifies servers using the base64
+// encoded PEM caBundle, or the shared httpClient if caBundle is empty.
+func newHTTPClient(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return &httpClient, nil
+	}
+	ca, err := base64.StdEncoding.DecodeString(caBundle)
+	if err != nil {
+		return nil, errors.New("Error decoding caBundle: " + err.Error())
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("caBundle contains no PEM certificates")
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: certPool},
+		},
+	}, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Issuer signs certificate signing requests generated by the controller.
+// Issuers are used for Certificates that are not backed by an ACME
+// certificate authority.
+type Issuer interface {
+	// Sign submits the PEM encoded certificate signing request to the
+	// certificate authority.
+	Sign(csr []byte) (*IssuedCertificate, error)
+}
+
+// ErrIssuancePending is returned by an Issuer when the certificate signing
+// request was accepted but the certificate has not been issued yet. The
+// request is retried during the next reconciliation.
+var ErrIssuancePending = errors.New("certificate issuance pending")
+
+// IssuedCertificate holds the PEM encoded certificate chain returned by an
+// Issuer and, when known, the certificate of the issuing CA.
+type IssuedCertificate struct {
+	Certificate []byte
+	CA          []byte
+}
+
+func newIssuer(c Certificate) (Issuer, error) {
+	switch c.Spec.Issuer.Type {
+	case "vault":
+		return newVaultIssuer(c)
+	case "kubernetes":
+		return newKubernetesIssuer(c)
+	case "cfssl":
+		return newCFSSLIssuer(c)
+	case "exec":
+		return newExecIssuer(c)
+	case "manual":
+		return newManualIssuer(c)
+	}
+	return nil, fmt.Errorf("Unknown issuer type %q for %s", c.Spec.Issuer.Type, c.Metadata.Name)
+}
+
+func processIssuedCertificate(c Certificate, issuer Issuer, db *bolt.DB) error {
+	account, err := findAccount(c.Spec.Domain, db)
+	if err != nil {
+		return err
+	}
+
+	if account == nil {
+		log.Printf("Creating new %s issuer account: %s", c.Spec.Issuer.Type, c.Spec.Domain)
+		certificateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		account = &Account{
+			Email:          c.Spec.Email,
+			CertificateKey: certificateKey,
+			Domain:         c.Spec.Domain,
+			Issuer:         c.Spec.Issuer.Type,
+		}
+
+		// Save the key before signing so pending requests are resumed
+		// with the same key.
+		err = saveAccount(account, db)
+		if err != nil {
+			return errors.New("Error saving account" + err.Error())
+		}
+	}
+
+	if account.Certificate == nil || needsRenewal(account.Certificate) {
+		log.Printf("Requesting %s certificate from %s issuer", c.Spec.Domain, c.Spec.Issuer.Type)
+		der, err := newCSR(c.Spec.Domain, c.Spec.AltNames, account.CertificateKey)
+		if err != nil {
+			return err
+		}
+		csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+		err = markCertificateIssuing(c, "Signing", "Requesting a certificate from the "+c.Spec.Issuer.Type+" issuer")
+		if err != nil {
+			log.Printf("Error updating certificate %s/%s status: %s", c.Metadata.Namespace, c.Metadata.Name, err)
+		}
+
+		issued, err := issuer.Sign(csr)
+		if err == ErrIssuancePending {
+			log.Printf("%s certificate issuance pending", c.Spec.Domain)
+			recorder.normal(c, "IssuancePending", "Waiting for the %s issuer to sign the certificate for %s", c.Spec.Issuer.Type, c.Spec.Domain)
+			return nil
+		}
+		if err != nil {
+			return errors.New("Error signing certificate: " + err.Error())
+		}
+		account.Certificate = issued.Certificate
+		account.CA = issued.CA
+
+		err = saveAccount(account, db)
+		if err != nil {
+			return errors.New("Error saving account" + err.Error())
+		}
+		recorder.normal(c, "Issued", "Issued certificate for %s by the %s issuer: %s", c.Spec.Domain, c.Spec.Issuer.Type, describeCertificate(issued.Certificate))
+	}
+
+	key := pem.EncodeToMemory(&pem.Block{
+		Type:    "RSA PRIVATE KEY",
+		Headers: nil,
+		Bytes:   x509.MarshalPKCS1PrivateKey(account.CertificateKey),
+	})
+	err = syncKubernetesSecret(c, account.Certificate, key, account.CA)
+	if err != nil {
+		return errors.New("Error creating Kubernetes secret: " + err.Error())
+	}
+	return nil
+}
+
+// needsRenewal reports whether the leaf certificate in the PEM encoded chain
+// has less than a third of its lifetime remaining.
+func needsRenewal(chain []byte) bool {
+	cert := parseLeafCertificate(chain)
+	if cert == nil {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return time.Until(cert.NotAfter) < lifetime/3
+}
@@ -0,0 +1,365 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountTokenFile = serviceAccountDir + "/token"
+)
+
+var (
+	kubeconfig   = ""
+	kubeAPIQPS   = 5.0
+	kubeAPIBurst = 10
+)
+
+// kubeAPI is the client used for all Kubernetes API requests. It is replaced
+// in main using newKubeClient.
+var kubeAPI = newProxyKubeClient()
+
+// kubeClient sends requests to the Kubernetes API server, authenticating
+// with a bearer token or a client certificate.
+type kubeClient struct {
+	host        string
+	token       string
+	tokenFile   string
+	client      *http.Client
+	watchClient *http.Client
+	limiter     *rateLimiter
+}
+
+// apiError is returned when the API server responds with an unexpected
+// status code. Message holds the message of the returned Status, if any.
+type apiError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s failed: %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s failed: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+// isNotFound returns true if err is an API error with status 404.
+func isNotFound(err error) bool {
+	e, ok := err.(*apiError)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// newKubeClient returns a client configured from the -kubeconfig file, the
+// in-cluster service account, or a kubectl proxy on 127.0.0.1:8001, in that
+// order.
+func newKubeClient() (*kubeClient, string, error) {
+	if kubeconfig != "" {
+		c, err := loadKubeconfig(kubeconfig)
+		return c, "kubeconfig " + kubeconfig, err
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" {
+		return newProxyKubeClient(), "kubectl proxy", nil
+	}
+	if port == "" {
+		port = "443"
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, "", errors.New("Error reading service account CA: " + err.Error())
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(ca) {
+		return nil, "", errors.New("Service account CA contains no PEM certificates")
+	}
+	c := newTLSKubeClient("https://"+net.JoinHostPort(host, port), &tls.Config{RootCAs: certPool})
+	c.tokenFile = serviceAccountTokenFile
+	return c, "in-cluster service account", nil
+}
+
+// newProxyKubeClient returns a client for a kubectl proxy running on
+// 127.0.0.1:8001.
+func newProxyKubeClient() *kubeClient {
+	return &kubeClient{
+		host:        "http://127.0.0.1:8001",
+		client:      &http.Client{Timeout: 30 * time.Second},
+		watchClient: &http.Client{},
+		limiter:     newRateLimiter(kubeAPIQPS, kubeAPIBurst),
+	}
+}
+
+func newTLSKubeClient(host string, config *tls.Config) *kubeClient {
+	transport := &http.Transport{TLSClientConfig: config}
+	return &kubeClient{
+		host:        strings.TrimRight(host, "/"),
+		client:      &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		watchClient: &http.Client{Transport: transport},
+		limiter:     newRateLimiter(kubeAPIQPS, kubeAPIBurst),
+	}
+}
+
+// kubeconfigFile is the subset of the kubeconfig format used by the
+// controller.
+type kubeconfigFile struct {
+	CurrentContext string `json:"current-context"`
+	Contexts       []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Clusters []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         string `json:"client-key-data"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+// loadKubeconfig returns a client for the current context of the kubeconfig
+// file at path. Only the JSON kubeconfig format is supported.
+func loadKubeconfig(path string) (*kubeClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config kubeconfigFile
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.New("Error decoding kubeconfig, convert it to JSON using kubectl config view --raw -o json: " + err.Error())
+	}
+
+	var clusterName, userName string
+	for _, context := range config.Contexts {
+		if context.Name == config.CurrentContext {
+			clusterName, userName = context.Context.Cluster, context.Context.User
+		}
+	}
+	if clusterName == "" {
+		return nil, fmt.Errorf("Context %q not found in kubeconfig", config.CurrentContext)
+	}
+
+	tlsConfig := &tls.Config{}
+	var server string
+	for _, cluster := range config.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		server = cluster.Cluster.Server
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+		ca, err := kubeconfigData(cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority)
+		if err != nil {
+			return nil, err
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, errors.New("Kubeconfig certificate authority contains no PEM certificates")
+			}
+		}
+	}
+	if server == "" {
+		return nil, fmt.Errorf("Cluster %q not found in kubeconfig", clusterName)
+	}
+
+	c := newTLSKubeClient(server, tlsConfig)
+	for _, user := range config.Users {
+		if user.Name != userName {
+			continue
+		}
+		c.token, c.tokenFile = user.User.Token, user.User.TokenFile
+		cert, err := kubeconfigData(user.User.ClientCertificateData, user.User.ClientCertificate)
+		if err != nil {
+			return nil, err
+		}
+		key, err := kubeconfigData(user.User.ClientKeyData, user.User.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil && key != nil {
+			keyPair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, errors.New("Error loading kubeconfig client certificate: " + err.Error())
+			}
+			tlsConfig.Certificates = []tls.Certificate{keyPair}
+		}
+	}
+	return c, nil
+}
+
+// kubeconfigData returns the base64 decoded data, or the contents of the
+// file at path if data is empty.
+func kubeconfigData(data, path string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path != "" {
+		return ioutil.ReadFile(path)
+	}
+	return nil, nil
+}
+
+// do sends a request with the JSON encoding of body, if not nil, and decodes
+// the JSON response into v, if not nil.
+func (c *kubeClient) do(method, path string, body, v interface{}) error {
+	resp, err := c.send(c.client, method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, v)
+}
+
+// patch sends a JSON merge patch request and decodes the JSON response into
+// v, if not nil.
+func (c *kubeClient) patch(path string, body, v interface{}) error {
+	resp, err := c.send(c.client, "PATCH", path, "application/merge-patch+json", body)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, v)
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if v == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// watch starts a watch request. The caller must close the response body.
+func (c *kubeClient) watch(path string) (*http.Response, error) {
+	return c.send(c.watchClient, "GET", path, "", nil)
+}
+
+func (c *kubeClient) send(client *http.Client, method, path, contentType string, body interface{}) (*http.Response, error) {
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	if body != nil {
+		err := json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.host+path, buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	token := c.token
+	if c.tokenFile != "" {
+		// Service account tokens are rotated, so the token file is read for
+		// every request.
+		data, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, errors.New("Error reading API token: " + err.Error())
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	c.limiter.wait()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		e := &apiError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&status) == nil {
+			e.Message = status.Message
+		}
+		return nil, e
+	}
+	return resp, nil
+}
+
+// rateLimiter is a token bucket limiting the rate of API requests to qps
+// with bursts of up to burst requests.
+type rateLimiter struct {
+	mu     sync.Mutex
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(qps float64, burst int) *rateLimiter {
+	return &rateLimiter{qps: qps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a request may be sent.
+func (l *rateLimiter) wait() {
+	if l.qps <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.qps
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.qps * float64(time.Second))
+	l.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
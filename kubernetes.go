@@ -11,21 +11,20 @@
 package main
 
 import (
-	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"time"
 )
 
 var (
-	apiHost                   = "http://127.0.0.1:8001"
-	certificatesEndpoint      = "/apis/stable.hightower.com/v1/certificates"
-	certificatesWatchEndpoint = "/apis/stable.hightower.com/v1/certificates?watch=true"
+	certificatesEndpoint = "/apis/stable.hightower.com/v1/certificates"
 )
 
 type CertificateEvent struct {
@@ -34,18 +33,112 @@ type CertificateEvent struct {
 }
 
 type Certificate struct {
-	ApiVersion string          `json:"apiVersion"`
-	Kind       string          `json:"kind"`
-	Metadata   Metadata        `json:"metadata"`
-	Spec       CertificateSpec `json:"spec"`
+	ApiVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   Metadata           `json:"metadata"`
+	Spec       CertificateSpec    `json:"spec"`
+	Status     *CertificateStatus `json:"status,omitempty"`
 }
 
 type CertificateSpec struct {
-	Domain    string `json:"domain"`
-	Email     string `json:"email"`
-	Provider  string `json:"provider"`
-	Secret    string `json:"secret"`
-	SecretKey string `json:"secretKey"`
+	Domain      string           `json:"domain"`
+	AltNames    []string         `json:"altNames,omitempty"`
+	Email       string           `json:"email"`
+	Provider    string           `json:"provider"`
+	Secret      string           `json:"secret"`
+	SecretKey   string           `json:"secretKey"`
+	Credentials *CredentialsSpec `json:"credentials,omitempty"`
+	FollowCNAME bool             `json:"followCNAME"`
+	Propagation *PropagationSpec `json:"propagation,omitempty"`
+	Solvers     []SolverSpec     `json:"solvers,omitempty"`
+	Issuer      *IssuerSpec      `json:"issuer,omitempty"`
+	// RevokeOnDelete is omitted when false so the spec hash of existing
+	// Certificates does not change.
+	RevokeOnDelete bool `json:"revokeOnDelete,omitempty"`
+}
+
+// SolverSpec configures the DNS provider used to solve the dns-01 challenges
+// of the Certificate identifiers in Zones. A solver without zones matches
+// every identifier.
+type SolverSpec struct {
+	Zones       []string         `json:"zones"`
+	Provider    string           `json:"provider"`
+	Secret      string           `json:"secret"`
+	SecretKey   string           `json:"secretKey"`
+	Credentials *CredentialsSpec `json:"credentials,omitempty"`
+	FollowCNAME bool             `json:"followCNAME"`
+	Propagation *PropagationSpec `json:"propagation,omitempty"`
+}
+
+// CredentialsSpec configures where the credentials of a DNS provider are
+// read from. Key names the Secret key holding the provider config and Env
+// maps exec plugin environment variables to Secret keys. File is only
+// allowed in the provider options.
+type CredentialsSpec struct {
+	Secret string            `json:"secret"`
+	Key    string            `json:"key"`
+	Env    map[string]string `json:"env"`
+	File   string            `json:"file"`
+}
+
+// PropagationSpec configures the check for challenge record propagation to
+// the authoritative nameservers. Unset fields use the provider settings or
+// the defaults. Skip and ExtraWait are pointers so that an explicit false or
+// zero overrides the provider settings.
+type PropagationSpec struct {
+	Skip         *bool     `json:"skip,omitempty"`
+	Timeout      duration  `json:"timeout"`
+	PollInterval duration  `json:"pollInterval"`
+	Transport    string    `json:"transport"`
+	ExtraWait    *duration `json:"extraWait,omitempty"`
+}
+
+type IssuerSpec struct {
+	Type       string                `json:"type"`
+	Vault      *VaultIssuerSpec      `json:"vault,omitempty"`
+	Kubernetes *KubernetesIssuerSpec `json:"kubernetes,omitempty"`
+	CFSSL      *CFSSLIssuerSpec      `json:"cfssl,omitempty"`
+	Exec       *ExecIssuerSpec       `json:"exec,omitempty"`
+	Manual     *ManualIssuerSpec     `json:"manual,omitempty"`
+}
+
+type VaultIssuerSpec struct {
+	Address             string `json:"address"`
+	Path                string `json:"path"`
+	Role                string `json:"role"`
+	TTL                 string `json:"ttl"`
+	CABundle            string `json:"caBundle"`
+	KubernetesRole      string `json:"kubernetesRole"`
+	KubernetesMountPath string `json:"kubernetesMountPath"`
+	TokenSecret         string `json:"tokenSecret"`
+	TokenSecretKey      string `json:"tokenSecretKey"`
+}
+
+type KubernetesIssuerSpec struct {
+	SignerName  string   `json:"signerName"`
+	Usages      []string `json:"usages"`
+	AutoApprove bool     `json:"autoApprove"`
+}
+
+type CFSSLIssuerSpec struct {
+	Address          string `json:"address"`
+	Profile          string `json:"profile"`
+	Label            string `json:"label"`
+	CABundle         string `json:"caBundle"`
+	AuthKeySecret    string `json:"authKeySecret"`
+	AuthKeySecretKey string `json:"authKeySecretKey"`
+}
+
+type ExecIssuerSpec struct {
+	Plugin    string            `json:"plugin"`
+	Config    map[string]string `json:"config"`
+	Secret    string            `json:"secret"`
+	SecretKey string            `json:"secretKey"`
+}
+
+type ManualIssuerSpec struct {
+	ConfigMap    string `json:"configMap"`
+	SignedSecret string `json:"signedSecret"`
 }
 
 type CertificateList struct {
@@ -63,123 +156,308 @@ type Secret struct {
 	Type       string            `json:"type"`
 }
 
+type ConfigMap struct {
+	Kind       string            `json:"kind"`
+	ApiVersion string            `json:"apiVersion"`
+	Metadata   Metadata          `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
 type Metadata struct {
-	Annotations map[string]string `json:"annotations"`
-	Labels      map[string]string `json:"labels"`
-	Name        string            `json:"name"`
-	Namespace   string            `json:"namespace"`
+	Annotations       map[string]string `json:"annotations"`
+	Labels            map[string]string `json:"labels"`
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	Finalizers        []string          `json:"finalizers,omitempty"`
+	OwnerReferences   []OwnerReference  `json:"ownerReferences,omitempty"`
+	DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+	Generation        int64             `json:"generation,omitempty"`
 }
 
-func getCertificates() ([]Certificate, error) {
-	var resp *http.Response
-	var err error
-	for {
-		resp, err = http.Get(apiHost + certificatesEndpoint)
-		if err != nil {
-			log.Println(err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-		break
-	}
+type OwnerReference struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Controller *bool  `json:"controller,omitempty"`
+}
+
+type CertificateSigningRequest struct {
+	ApiVersion string                          `json:"apiVersion"`
+	Kind       string                          `json:"kind"`
+	Metadata   Metadata                        `json:"metadata"`
+	Spec       CertificateSigningRequestSpec   `json:"spec"`
+	Status     CertificateSigningRequestStatus `json:"status"`
+}
 
+type CertificateSigningRequestSpec struct {
+	Request    []byte   `json:"request"`
+	SignerName string   `json:"signerName"`
+	Usages     []string `json:"usages"`
+}
+
+type CertificateSigningRequestStatus struct {
+	Conditions  []CertificateSigningRequestCondition `json:"conditions,omitempty"`
+	Certificate []byte                               `json:"certificate,omitempty"`
+}
+
+type CertificateSigningRequestCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// getCertificates returns the Certificates and the resourceVersion of the
+// list.
+func getCertificates() ([]Certificate, string, error) {
 	var certList CertificateList
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&certList)
+	err := kubeAPI.do("GET", certificatesEndpoint, nil, &certList)
+	if err != nil {
+		return nil, "", errors.New("Error listing certificates: " + err.Error())
+	}
+	return certList.Items, certList.Metadata.ResourceVersion, nil
+}
+
+// getCertificate returns the Certificate, or nil if it does not exist.
+func getCertificate(namespace, name string) (*Certificate, error) {
+	var certificate Certificate
+	err := kubeAPI.do("GET", certificateEndpoint(namespace, name), nil, &certificate)
+	if isNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	return &certificate, nil
+}
+
+// putCertificateStatus replaces the status of the Certificate using the
+// status subresource. Changes to other fields are ignored by the API server.
+func putCertificateStatus(c *Certificate) error {
+	return kubeAPI.do("PUT", certificateEndpoint(c.Metadata.Namespace, c.Metadata.Name)+"/status", c, nil)
+}
+
+// setCertificateFinalizers replaces the finalizers of the Certificate. The
+// update fails if the Certificate changed since it was read.
+func setCertificateFinalizers(c *Certificate, finalizers []string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": c.Metadata.ResourceVersion,
+		},
+	}
+	return kubeAPI.patch(certificateEndpoint(c.Metadata.Namespace, c.Metadata.Name), patch, nil)
+}
 
-	return certList.Items, nil
+func certificateEndpoint(namespace, name string) string {
+	return "/apis/stable.hightower.com/v1/namespaces/" + namespace + "/certificates/" + name
 }
 
-func monitorCertificateEvents() (<-chan CertificateEvent, <-chan error) {
+// monitorCertificateEvents watches Certificates from resourceVersion, the
+// version of the list processed by syncCertificates, so existing
+// Certificates are not replayed as ADDED events.
+func monitorCertificateEvents(resourceVersion string) (<-chan CertificateEvent, <-chan error) {
 	events := make(chan CertificateEvent)
-	errc := make(chan error, 1)
+	watchEvents, errc := resumableWatch(certificatesEndpoint, resourceVersion)
 	go func() {
-		for {
-			resp, err := http.Get(apiHost + certificatesWatchEndpoint)
+		for event := range watchEvents {
+			var certificate Certificate
+			err := json.Unmarshal(event.Object, &certificate)
 			if err != nil {
-				errc <- err
-				time.Sleep(5 * time.Second)
+				log.Println("Error decoding certificate event: " + err.Error())
 				continue
 			}
-			if resp.StatusCode != 200 {
-				errc <- errors.New("Invalid status code: " + resp.Status)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-
-			decoder := json.NewDecoder(resp.Body)
-			for {
-				var event CertificateEvent
-				err = decoder.Decode(&event)
-				if err != nil {
-					errc <- err
-					break
-				}
-				events <- event
-			}
+			events <- CertificateEvent{event.Type, certificate}
 		}
 	}()
 
 	return events, errc
 }
 
-func getDNSConfigFromSecret(name, namespace, key string) ([]byte, error) {
-	resp, err := http.Get(secretEndpoint(namespace, name))
+func getSecretValue(name, namespace, key string) ([]byte, error) {
+	secret, err := getSecret(name, namespace)
 	if err != nil {
 		return nil, err
 	}
-	var secret Secret
-	decoder := json.NewDecoder(resp.Body)
-	err = decoder.Decode(&secret)
-	if err != nil {
-		return nil, err
+	if secret == nil {
+		return nil, fmt.Errorf("Secret %s not found", name)
 	}
 
 	data, ok := secret.Data[key]
 	if !ok {
 		return nil, fmt.Errorf("Secret key %s not found", key)
 	}
-	config, err := base64.StdEncoding.DecodeString(data)
+	value, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return config, nil
+	return value, nil
 }
 
-func deleteKubernetesSecret(c Certificate) error {
-
-	req, err := http.NewRequest("DELETE", secretEndpoint(c.Metadata.Namespace, c.Spec.Domain), nil)
+// getSecret returns the named Secret or nil if it does not exist.
+func getSecret(name, namespace string) (*Secret, error) {
+	var secret Secret
+	err := kubeAPI.do("GET", secretEndpoint(namespace, name), nil, &secret)
+	if isNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	return &secret, nil
+}
+
+// setSecretValue sets key in the named Opaque Secret to value, creating the
+// Secret if it does not exist.
+func setSecretValue(name, namespace, key string, value []byte) error {
+	secret, err := getSecret(name, namespace)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Deleting %s secret failed: %s", c.Spec.Domain, resp.Status)
+
+	if secret == nil {
+		secret = &Secret{
+			ApiVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   Metadata{Name: name},
+			Data:       map[string]string{key: base64.StdEncoding.EncodeToString(value)},
+			Type:       "Opaque",
+		}
+		return kubeAPI.do("POST", secretsEndpoint(namespace), secret, nil)
 	}
-	return nil
+
+	if secret.Data == nil {
+		secret.Data = make(map[string]string)
+	}
+	secret.Data[key] = base64.StdEncoding.EncodeToString(value)
+	return kubeAPI.do("PUT", secretEndpoint(namespace, name), secret, nil)
+}
+
+func deleteKubernetesSecret(c Certificate) error {
+	return kubeAPI.do("DELETE", secretEndpoint(c.Metadata.Namespace, c.Spec.Domain), nil, nil)
+}
+
+func secretsEndpoint(namespace string) string {
+	return "/api/v1/namespaces/" + namespace + "/secrets"
 }
 
 func secretEndpoint(namespace string, name string) string {
-	return apiHost + "/api/v1/namespaces/" + namespace + "/secrets/" + name
+	return secretsEndpoint(namespace) + "/" + name
 }
 
-func syncKubernetesSecret(requested Certificate, cert, key []byte) error {
+// Labels and annotations set on the Kubernetes TLS secrets of Certificates.
+const (
+	managedByLabel        = "app.kubernetes.io/managed-by"
+	certificateLabel      = "stable.hightower.com/certificate"
+	certificateAnnotation = "stable.hightower.com/certificate"
+	issuerAnnotation      = "stable.hightower.com/issuer"
+	altNamesAnnotation    = "stable.hightower.com/alt-names"
+	serialAnnotation      = "stable.hightower.com/serial"
+	expiryAnnotation      = "stable.hightower.com/expiry"
+)
+
+// maxLabelValueLength is the maximum length of a Kubernetes label value.
+const maxLabelValueLength = 63
+
+// certificateLabelValue returns the value of the certificate label for the
+// named Certificate. Names longer than a label value are shortened and
+// followed by a hash of the full name, which is kept in the certificate
+// annotation.
+func certificateLabelValue(name string) string {
+	if len(name) <= maxLabelValueLength {
+		return name
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:32]
+	prefix := strings.TrimRight(name[:maxLabelValueLength-len(hash)-1], "-.")
+	return prefix + "-" + hash
+}
+
+// secretMetadata returns the metadata of the Kubernetes TLS secret of the
+// Certificate. The Secret is owned by the Certificate, so it is garbage
+// collected when the Certificate is deleted.
+func secretMetadata(requested Certificate, cert []byte) Metadata {
 	metadata := Metadata{
-		Annotations: make(map[string]string),
-		Labels:      make(map[string]string),
+		Annotations: map[string]string{
+			certificateAnnotation: requested.Metadata.Name,
+			issuerAnnotation:      issuerType(requested),
+		},
+		Labels: map[string]string{
+			managedByLabel:   "kube-cert-manager",
+			certificateLabel: certificateLabelValue(requested.Metadata.Name),
+		},
+		Name: requested.Spec.Domain,
+	}
+	if leaf := parseLeafCertificate(cert); leaf != nil {
+		metadata.Annotations[altNamesAnnotation] = strings.Join(leaf.DNSNames, ",")
+		metadata.Annotations[serialAnnotation] = fmt.Sprintf("%x", leaf.SerialNumber)
+		metadata.Annotations[expiryAnnotation] = leaf.NotAfter.UTC().Format(time.RFC3339)
 	}
-	metadata.Name = requested.Spec.Domain
+	if requested.Metadata.UID != "" {
+		controller := true
+		metadata.OwnerReferences = []OwnerReference{{
+			ApiVersion: requested.ApiVersion,
+			Kind:       "Certificate",
+			Name:       requested.Metadata.Name,
+			UID:        requested.Metadata.UID,
+			Controller: &controller,
+		}}
+	}
+	return metadata
+}
+
+// mergeSecretMetadata adds the labels, annotations and owner references of
+// desired to current, keeping any others. It returns true if current
+// changed.
+func mergeSecretMetadata(current *Metadata, desired Metadata) bool {
+	changed := false
+	if current.Labels == nil {
+		current.Labels = make(map[string]string)
+	}
+	for k, v := range desired.Labels {
+		if current.Labels[k] != v {
+			current.Labels[k] = v
+			changed = true
+		}
+	}
+	if current.Annotations == nil {
+		current.Annotations = make(map[string]string)
+	}
+	for k, v := range desired.Annotations {
+		if current.Annotations[k] != v {
+			current.Annotations[k] = v
+			changed = true
+		}
+	}
+	// Only one owner may be the controller, so a Secret controlled by
+	// another object is not claimed.
+	for _, ref := range desired.OwnerReferences {
+		owned := false
+		for _, r := range current.OwnerReferences {
+			if r.UID == ref.UID || (r.Controller != nil && *r.Controller) {
+				owned = true
+			}
+		}
+		if !owned {
+			current.OwnerReferences = append(current.OwnerReferences, ref)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func syncKubernetesSecret(requested Certificate, cert, key, ca []byte) error {
+	metadata := secretMetadata(requested, cert)
 
 	data := make(map[string]string)
 	data["tls.crt"] = base64.StdEncoding.EncodeToString(cert)
 	data["tls.key"] = base64.StdEncoding.EncodeToString(key)
+	if ca != nil {
+		data["ca.crt"] = base64.StdEncoding.EncodeToString(ca)
+	}
 
 	secret := &Secret{
 		ApiVersion: "v1",
@@ -189,67 +467,128 @@ func syncKubernetesSecret(requested Certificate, cert, key []byte) error {
 		Type:       "kubernetes.io/tls",
 	}
 	endPoint := secretEndpoint(requested.Metadata.Namespace, requested.Spec.Domain)
-	resp, err := http.Get(endPoint)
-	if err != nil {
-		return err
-	}
 
-	if resp.StatusCode == 200 {
-		// compare current cert
-		var currentSecret Secret
-		d, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		resp.Body.Close()
-		err = json.Unmarshal(d, &currentSecret)
+	var currentSecret Secret
+	err := kubeAPI.do("GET", endPoint, nil, &currentSecret)
+	if isNotFound(err) {
+		log.Printf("%s secret missing.", requested.Spec.Domain)
+		err := kubeAPI.do("POST", secretsEndpoint(requested.Metadata.Namespace), secret, nil)
 		if err != nil {
 			return err
 		}
-		if currentSecret.Data["tls.crt"] != secret.Data["tls.crt"] || currentSecret.Data["tls.key"] != secret.Data["tls.key"] {
-			log.Printf("%s secret out of sync.", requested.Spec.Domain)
-			currentSecret.Data = secret.Data
-			b := make([]byte, 0)
-			body := bytes.NewBuffer(b)
-			err := json.NewEncoder(body).Encode(currentSecret)
-			if err != nil {
-				return err
-			}
-			req, err := http.NewRequest("PUT", endPoint, body)
-			if err != nil {
-				return err
-			}
-			req.Header.Add("Content-Type", "application/json")
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return err
-			}
-			if resp.StatusCode != 200 {
-				return errors.New("Updating secret failed:" + resp.Status)
-			}
-			log.Printf("Syncing %s secret complete.", requested.Spec.Domain)
-		}
+		log.Printf("%s secret created.", requested.Spec.Domain)
+		recorder.normal(requested, "SecretCreated", "Created Kubernetes TLS secret %s", requested.Spec.Domain)
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
-	if resp.StatusCode == 404 {
-		log.Printf("%s secret missing.", requested.Spec.Domain)
-		var b []byte
-		body := bytes.NewBuffer(b)
-		err := json.NewEncoder(body).Encode(secret)
+	// compare current cert
+	if currentSecret.Data["tls.crt"] != secret.Data["tls.crt"] || currentSecret.Data["tls.key"] != secret.Data["tls.key"] || currentSecret.Data["ca.crt"] != secret.Data["ca.crt"] {
+		log.Printf("%s secret out of sync.", requested.Spec.Domain)
+		currentSecret.Data = secret.Data
+		mergeSecretMetadata(&currentSecret.Metadata, metadata)
+		err := kubeAPI.do("PUT", endPoint, currentSecret, nil)
 		if err != nil {
 			return err
 		}
+		log.Printf("Syncing %s secret complete.", requested.Spec.Domain)
+		recorder.normal(requested, "SecretUpdated", "Updated Kubernetes TLS secret %s", requested.Spec.Domain)
+		return nil
+	}
 
-		resp, err := http.Post(apiHost+"/api/v1/namespaces/"+requested.Metadata.Namespace+"/secrets", "application/json", body)
+	// Secrets created by earlier versions have no labels, annotations or
+	// owner reference.
+	if mergeSecretMetadata(&currentSecret.Metadata, metadata) {
+		log.Printf("Updating %s secret metadata.", requested.Spec.Domain)
+		return kubeAPI.do("PUT", endPoint, currentSecret, nil)
+	}
+	return nil
+}
+
+func certificateSigningRequestEndpoint(name string) string {
+	return "/apis/certificates.k8s.io/v1/certificatesigningrequests/" + name
+}
+
+// getCertificateSigningRequest returns the named CertificateSigningRequest or
+// nil if it does not exist.
+func getCertificateSigningRequest(name string) (*CertificateSigningRequest, error) {
+	var csr CertificateSigningRequest
+	err := kubeAPI.do("GET", certificateSigningRequestEndpoint(name), nil, &csr)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &csr, nil
+}
+
+func createCertificateSigningRequest(csr *CertificateSigningRequest) error {
+	return kubeAPI.do("POST", "/apis/certificates.k8s.io/v1/certificatesigningrequests", csr, nil)
+}
+
+func approveCertificateSigningRequest(csr *CertificateSigningRequest) error {
+	return kubeAPI.do("PUT", certificateSigningRequestEndpoint(csr.Metadata.Name)+"/approval", csr, nil)
+}
+
+// getCertificateSigningRequests returns the CertificateSigningRequests
+// matching the label selector.
+func getCertificateSigningRequests(selector string) ([]CertificateSigningRequest, error) {
+	var list struct {
+		Items []CertificateSigningRequest `json:"items"`
+	}
+	query := url.Values{"labelSelector": {selector}}
+	err := kubeAPI.do("GET", "/apis/certificates.k8s.io/v1/certificatesigningrequests?"+query.Encode(), nil, &list)
+	return list.Items, err
+}
+
+func deleteCertificateSigningRequest(name string) error {
+	err := kubeAPI.do("DELETE", certificateSigningRequestEndpoint(name), nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func configMapEndpoint(namespace string, name string) string {
+	return "/api/v1/namespaces/" + namespace + "/configmaps/" + name
+}
+
+func syncKubernetesConfigMap(namespace string, configMap *ConfigMap) error {
+	endPoint := configMapEndpoint(namespace, configMap.Metadata.Name)
+
+	var current ConfigMap
+	err := kubeAPI.do("GET", endPoint, nil, &current)
+	if isNotFound(err) {
+		err := kubeAPI.do("POST", "/api/v1/namespaces/"+namespace+"/configmaps", configMap, nil)
 		if err != nil {
 			return err
 		}
-		if resp.StatusCode != 201 {
-			return errors.New("Secrets: Unexpected HTTP status code" + resp.Status)
-		}
-		log.Printf("%s secret created.", requested.Spec.Domain)
+		log.Printf("%s config map created.", configMap.Metadata.Name)
 		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(current.Data, configMap.Data) {
+		return nil
+	}
+	current.Data = configMap.Data
+	err = kubeAPI.do("PUT", endPoint, current, nil)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s config map updated.", configMap.Metadata.Name)
 	return nil
 }
+
+func deleteKubernetesConfigMap(namespace, name string) error {
+	err := kubeAPI.do("DELETE", configMapEndpoint(namespace, name), nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
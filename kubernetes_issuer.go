@@ -0,0 +1,173 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var defaultCSRUsages = []string{"digital signature", "key encipherment", "server auth"}
+
+const certificateNamespaceLabel = "stable.hightower.com/certificate-namespace"
+
+// csrNamePrefixLength limits the namespace and name part of the
+// CertificateSigningRequest name so the csrNameHashLength hash suffix
+// always fits in 63 characters.
+const (
+	csrNamePrefixLength = 29
+	csrNameHashLength   = 32
+)
+
+// csrNamePrefix returns the shortened namespace and name part of the
+// CertificateSigningRequest names of a Certificate.
+func csrNamePrefix(namespace, name string) string {
+	prefix := namespace + "-" + name
+	if len(prefix) > csrNamePrefixLength {
+		prefix = prefix[:csrNamePrefixLength]
+	}
+	return strings.TrimRight(prefix, "-.")
+}
+
+// csrName returns the CertificateSigningRequest name for the csr, ending
+// with a fixed length hash of the namespace, name and csr.
+func csrName(namespace, name string, csr []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s/%s\n", namespace, name)
+	h.Write(csr)
+	hash := fmt.Sprintf("%x", h.Sum(nil))[:csrNameHashLength]
+	return csrNamePrefix(namespace, name) + "-" + hash
+}
+
+// deleteCertificateSigningRequests deletes the CertificateSigningRequests
+// created for the Certificate.
+func deleteCertificateSigningRequests(c Certificate) error {
+	requests, err := getCertificateSigningRequests(certificateLabel + "=" + certificateLabelValue(c.Metadata.Name))
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		namespace, ok := request.Metadata.Labels[certificateNamespaceLabel]
+		if namespace != c.Metadata.Namespace {
+			// Requests created before the namespace label was added are
+			// matched by name.
+			if ok || !strings.HasPrefix(request.Metadata.Name, c.Metadata.Namespace+"-"+c.Metadata.Name+"-") {
+				continue
+			}
+		}
+		log.Printf("Deleting certificate signing request %s", request.Metadata.Name)
+		err := deleteCertificateSigningRequest(request.Metadata.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type kubernetesIssuer struct {
+	spec      KubernetesIssuerSpec
+	name      string
+	namespace string
+}
+
+func newKubernetesIssuer(c Certificate) (*kubernetesIssuer, error) {
+	spec := c.Spec.Issuer.Kubernetes
+	if spec == nil || spec.SignerName == "" {
+		return nil, fmt.Errorf("Kubernetes issuer for %s requires a signerName", c.Metadata.Name)
+	}
+	return &kubernetesIssuer{*spec, c.Metadata.Name, c.Metadata.Namespace}, nil
+}
+
+// Sign submits the csr as a CertificateSigningRequest, approving it if
+// configured, and returns the certificate once it is signed. The request is
+// checked once, as Certificates are processed one at a time, and
+// ErrIssuancePending is returned until it is signed. The
+// CertificateSigningRequest name is derived from the csr so a request that
+// is still pending is picked up by later calls.
+func (k *kubernetesIssuer) Sign(csr []byte) (*IssuedCertificate, error) {
+	name := csrName(k.namespace, k.name, csr)
+
+	request, err := getCertificateSigningRequest(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The CertificateSigningRequest for a renewal reuses the certificate
+	// key, so an earlier request may still hold the certificate being
+	// replaced.
+	if request != nil && request.Status.Certificate != nil && needsRenewal(request.Status.Certificate) {
+		log.Printf("Deleting stale certificate signing request %s", name)
+		if err := deleteCertificateSigningRequest(name); err != nil {
+			return nil, err
+		}
+		request = nil
+	}
+
+	if request == nil {
+		usages := k.spec.Usages
+		if len(usages) == 0 {
+			usages = defaultCSRUsages
+		}
+		request = &CertificateSigningRequest{
+			ApiVersion: "certificates.k8s.io/v1",
+			Kind:       "CertificateSigningRequest",
+			Metadata: Metadata{
+				Name: name,
+				Labels: map[string]string{
+					certificateLabel:          certificateLabelValue(k.name),
+					certificateNamespaceLabel: k.namespace,
+				},
+				Annotations: map[string]string{
+					certificateAnnotation: k.name,
+				},
+			},
+			Spec: CertificateSigningRequestSpec{
+				Request:    csr,
+				SignerName: k.spec.SignerName,
+				Usages:     usages,
+			},
+		}
+		log.Printf("Creating certificate signing request %s", name)
+		if err := createCertificateSigningRequest(request); err != nil {
+			return nil, err
+		}
+	}
+
+	approved := false
+	for _, condition := range request.Status.Conditions {
+		switch condition.Type {
+		case "Denied", "Failed":
+			return nil, fmt.Errorf("Certificate signing request %s %s: %s", name, condition.Reason, condition.Message)
+		case "Approved":
+			approved = true
+		}
+	}
+
+	if request.Status.Certificate != nil {
+		return &IssuedCertificate{Certificate: request.Status.Certificate}, nil
+	}
+
+	if !approved && k.spec.AutoApprove {
+		request.Status.Conditions = append(request.Status.Conditions, CertificateSigningRequestCondition{
+			Type:    "Approved",
+			Status:  "True",
+			Reason:  "KubeCertManagerApprove",
+			Message: "Approved by kube-cert-manager",
+		})
+		log.Printf("Approving certificate signing request %s", name)
+		if err := approveCertificateSigningRequest(request); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrIssuancePending
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCertificateLabelValue(t *testing.T) {
+	short := strings.Repeat("a", maxLabelValueLength)
+	if got := certificateLabelValue(short); got != short {
+		t.Errorf("certificateLabelValue(%q) = %q, want the name", short, got)
+	}
+
+	long := strings.Repeat("a", 29) + "-" + strings.Repeat("b", 223)
+	other := strings.Repeat("a", 29) + "-" + strings.Repeat("c", 223)
+	value := certificateLabelValue(long)
+	if len(value) > maxLabelValueLength {
+		t.Errorf("certificateLabelValue(%q) = %q, longer than %d characters", long, value, maxLabelValueLength)
+	}
+	if !strings.HasPrefix(value, strings.Repeat("a", 29)+"-") || strings.Contains(value, "--") {
+		t.Errorf("certificateLabelValue(%q) = %q, want the shortened name and a hash", long, value)
+	}
+	if value == certificateLabelValue(other) {
+		t.Errorf("certificateLabelValue(%q) = certificateLabelValue(%q) = %q", long, other, value)
+	}
+}
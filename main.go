@@ -26,19 +26,54 @@ import (
 )
 
 var (
-	dataDir      = "/var/lib/cert-manager"
-	discoveryURL = "https://acme-staging.api.letsencrypt.org/directory"
-	syncInterval = 120
+	dataDir           = "/var/lib/cert-manager"
+	discoveryURL      = "https://acme-staging.api.letsencrypt.org/directory"
+	syncInterval      = 120
+	dnsProviderConfig = ""
 )
 
 func main() {
 	flag.StringVar(&dataDir, "data-dir", dataDir, "Data directory path.")
 	flag.StringVar(&discoveryURL, "acme-url", discoveryURL, "AMCE endpoint URL.")
 	flag.IntVar(&syncInterval, "sync-interval", syncInterval, "Sync interval in seconds.")
+	flag.StringVar(&kubeconfig, "kubeconfig", kubeconfig, "Path to a JSON kubeconfig file, used when running outside the cluster.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", kubeAPIQPS, "Maximum Kubernetes API requests per second.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", kubeAPIBurst, "Maximum burst of Kubernetes API requests.")
+	flag.StringVar(&dnsProviderConfig, "dns-provider-config", dnsProviderConfig, "DNS provider options file path.")
+	flag.StringVar(&dnsResolvers, "dns-resolvers", dnsResolvers, "Comma separated list of recursive nameservers. Defaults to the nameservers in /etc/resolv.conf.")
+	flag.StringVar(&dnsServerZone, "dns-server-zone", dnsServerZone, "Zone served by the embedded DNS server. The server is disabled when empty.")
+	flag.StringVar(&dnsServerAddr, "dns-server-addr", dnsServerAddr, "Listen address of the embedded DNS server.")
+	flag.StringVar(&dnsServerNameserver, "dns-server-nameserver", dnsServerNameserver, "Nameserver name published by the embedded DNS server. Defaults to the zone name.")
+	flag.StringVar(&credentialsNamespace, "credentials-namespace", credentialsNamespace, "Namespace of the secrets referenced by the credentials in the DNS provider options.")
+	flag.StringVar(&pluginPath, "plugin-path", pluginPath, "List of directories searched for exec plugins, separated by colons.")
+	flag.DurationVar(&pluginTimeout, "plugin-timeout", pluginTimeout, "Default timeout for exec plugin commands.")
 	flag.Parse()
 
 	log.Println("Starting Kubernetes Certificate Controller...")
 
+	client, source, err := newKubeClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	kubeAPI = client
+	log.Printf("Using the Kubernetes API at %s (%s)", client.host, source)
+
+	if dnsProviderConfig != "" {
+		err := loadDNSProviderOptions(dnsProviderConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if dnsServerZone != "" {
+		serveDNS()
+	}
+
+	for name, path := range discoverPlugins() {
+		log.Printf("Found exec plugin %s: %s", name, path)
+	}
+
+	http.HandleFunc("/debug/plugins", pluginsHandler)
 	go func() {
 		log.Println(http.ListenAndServe("127.0.0.1:6060", nil))
 	}()
@@ -53,6 +88,14 @@ func main() {
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
+		_, err = tx.CreateBucketIfNotExists([]byte(certificatesBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(challengeRecordsBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
 		return nil
 	})
 	if err != nil {
@@ -60,8 +103,14 @@ func main() {
 	}
 	log.Println("Kubernetes Certificate Controller started successfully.")
 
-	// Process all Certificates definitions during the startup process.
-	err = syncCertificates(db)
+	// Clean up challenge records left behind by a previous run before any
+	// Certificates are processed.
+	sweepChallengeRecords(db, true)
+
+	// Process all Certificates definitions during the startup process. The
+	// watch starts from the version of the processed list, or lists the
+	// Certificates itself and reports them as added if the sync failed.
+	resourceVersion, err := syncCertificates(db)
 	if err != nil {
 		log.Println(err)
 	}
@@ -73,7 +122,7 @@ func main() {
 	// process them asynchronously.
 	log.Println("Watching for certificate events.")
 	wg.Add(1)
-	watchCertificateEvents(db, doneChan, &wg)
+	watchCertificateEvents(db, resourceVersion, doneChan, &wg)
 
 	// Start the certificate reconciler that will ensure all Certificate
 	// definitions are backed by a LetsEncrypt certificate and a Kubernetes
@@ -82,6 +131,10 @@ func main() {
 	wg.Add(1)
 	reconcileCertificates(syncInterval, db, doneChan, &wg)
 
+	// Start the janitor that retries failed challenge record cleanups.
+	wg.Add(1)
+	runChallengeJanitor(db, doneChan, &wg)
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
@@ -0,0 +1,156 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// signedCertificateAnnotation holds a PEM encoded certificate chain supplied
+// by an operator for Certificates using the manual issuer.
+const signedCertificateAnnotation = "stable.hightower.com/signed-certificate"
+
+// manualIssuer publishes certificate signing requests to a ConfigMap and
+// waits for an operator to supply the signed certificate chain using the
+// signed-certificate annotation or a Secret.
+type manualIssuer struct {
+	spec        ManualIssuerSpec
+	name        string
+	namespace   string
+	domain      string
+	annotations map[string]string
+}
+
+func newManualIssuer(c Certificate) (*manualIssuer, error) {
+	spec := ManualIssuerSpec{}
+	if c.Spec.Issuer.Manual != nil {
+		spec = *c.Spec.Issuer.Manual
+	}
+	if spec.ConfigMap == "" {
+		spec.ConfigMap = c.Metadata.Name + "-csr"
+	}
+	return &manualIssuer{spec, c.Metadata.Name, c.Metadata.Namespace, c.Spec.Domain, c.Metadata.Annotations}, nil
+}
+
+func (m *manualIssuer) Sign(csr []byte) (*IssuedCertificate, error) {
+	issued, err := m.signedCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	if issued != nil {
+		err := verifySignedCertificate(csr, issued.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("Signed certificate for %s rejected: %s", m.name, err)
+		}
+		if !needsRenewal(issued.Certificate) {
+			log.Printf("Signed certificate for %s supplied", m.domain)
+			err := deleteKubernetesConfigMap(m.namespace, m.spec.ConfigMap)
+			if err != nil {
+				return nil, err
+			}
+			return issued, nil
+		}
+	}
+
+	configMap := &ConfigMap{
+		ApiVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: Metadata{
+			Name: m.spec.ConfigMap,
+			Labels: map[string]string{
+				"stable.hightower.com/certificate": m.name,
+			},
+		},
+		Data: map[string]string{
+			"domain":  m.domain,
+			"csr.pem": string(csr),
+		},
+	}
+	err = syncKubernetesConfigMap(m.namespace, configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if issued != nil {
+		log.Printf("Certificate for %s is due for renewal; sign the request in the %s config map", m.domain, m.spec.ConfigMap)
+	} else {
+		log.Printf("Waiting for a signed certificate for %s; sign the request in the %s config map", m.domain, m.spec.ConfigMap)
+	}
+	return nil, ErrIssuancePending
+}
+
+// signedCertificate returns the certificate chain supplied by the operator,
+// or nil if none has been supplied yet.
+func (m *manualIssuer) signedCertificate() (*IssuedCertificate, error) {
+	if m.spec.SignedSecret != "" {
+		secret, err := getSecret(m.spec.SignedSecret, m.namespace)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil || secret.Data["tls.crt"] == "" {
+			return nil, nil
+		}
+		cert, err := base64.StdEncoding.DecodeString(secret.Data["tls.crt"])
+		if err != nil {
+			return nil, err
+		}
+		issued := &IssuedCertificate{Certificate: cert}
+		if secret.Data["ca.crt"] != "" {
+			issued.CA, err = base64.StdEncoding.DecodeString(secret.Data["ca.crt"])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return issued, nil
+	}
+
+	if cert, ok := m.annotations[signedCertificateAnnotation]; ok && cert != "" {
+		return &IssuedCertificate{Certificate: []byte(cert)}, nil
+	}
+	return nil, nil
+}
+
+// verifySignedCertificate ensures the leaf certificate of the PEM encoded
+// chain was issued for the key of the PEM encoded csr.
+func verifySignedCertificate(csr, chain []byte) error {
+	csrBlock, _ := pem.Decode(csr)
+	if csrBlock == nil {
+		return errors.New("invalid certificate signing request")
+	}
+	request, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(chain)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	publicKey, ok := cert.PublicKey.(interface {
+		Equal(crypto.PublicKey) bool
+	})
+	if !ok || !publicKey.Equal(request.PublicKey) {
+		return errors.New("certificate does not match the certificate key")
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	pluginPath        = "/"
+	pluginTimeout     = 60 * time.Second
+	pluginOutputLimit = 64 * 1024
+)
+
+var (
+	pluginsMu sync.Mutex
+	plugins   = make(map[string]string)
+)
+
+// pluginError is returned when an exec plugin fails. It holds the captured,
+// size limited, output of the plugin.
+type pluginError struct {
+	Path   string
+	Err    error
+	Stdout []byte
+	Stderr []byte
+}
+
+func (e *pluginError) Error() string {
+	stderr := strings.TrimSpace(string(e.Stderr))
+	if stderr == "" {
+		return fmt.Sprintf("%s plugin failed: %s", filepath.Base(e.Path), e.Err)
+	}
+	return fmt.Sprintf("%s plugin failed: %s: %s", filepath.Base(e.Path), e.Err, stderr)
+}
+
+// discoverPlugins builds the inventory of exec plugins found in the
+// directories of pluginPath. The first executable found for a name wins.
+func discoverPlugins() map[string]string {
+	self, _ := os.Executable()
+
+	found := make(map[string]string)
+	for _, dir := range filepath.SplitList(pluginPath) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Printf("Error reading plugin directory %s: %s", dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if _, ok := found[entry.Name()]; ok || path == self {
+				continue
+			}
+			if entry.Mode().IsRegular() && entry.Mode().Perm()&0111 != 0 {
+				found[entry.Name()] = path
+			}
+		}
+	}
+
+	pluginsMu.Lock()
+	plugins = found
+	pluginsMu.Unlock()
+	return found
+}
+
+// findPlugin returns the path of the named exec plugin. The plugin
+// directories are scanned again if the plugin is not in the inventory.
+func findPlugin(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, filepath.Separator) {
+		return "", fmt.Errorf("Invalid plugin name %q", name)
+	}
+
+	pluginsMu.Lock()
+	path, ok := plugins[name]
+	pluginsMu.Unlock()
+	if ok {
+		return path, nil
+	}
+
+	if path, ok := discoverPlugins()[name]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("Plugin %q not found in %s", name, pluginPath)
+}
+
+// pluginsHandler lists the built-in DNS providers and discovered exec
+// plugins.
+func pluginsHandler(w http.ResponseWriter, r *http.Request) {
+	builtin := make([]string, 0)
+	for name := range dnsProviders {
+		builtin = append(builtin, name)
+	}
+	sort.Strings(builtin)
+
+	pluginsMu.Lock()
+	inventory := struct {
+		Providers []string          `json:"providers"`
+		Plugins   map[string]string `json:"plugins"`
+	}{builtin, plugins}
+	pluginsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(inventory)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// limitedBuffer is an io.Writer that keeps at most limit bytes and discards
+// the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.limit - b.Len(); room < len(p) {
+		if room < 0 {
+			room = 0
+		}
+		p = p[:room]
+		b.truncated = true
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+func (b *limitedBuffer) output() []byte {
+	if b.truncated {
+		return append(b.Bytes(), []byte("... (truncated)")...)
+	}
+	return b.Bytes()
+}
+
+// execPlugin runs the plugin at path and returns its stdout. The plugin runs
+// in its own process group which is killed if it does not exit before the
+// timeout.
+func execPlugin(path string, env []string, stdin []byte, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: pluginOutputLimit}
+	stderr := &limitedBuffer{limit: pluginOutputLimit}
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return stdout.output(), &pluginError{path, err, stdout.output(), stderr.output()}
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	dnsProviders["powerdns"] = newPowerDNSProvider
+}
+
+// powerDNSConfig is the provider secret format of the powerdns DNS provider.
+type powerDNSConfig struct {
+	APIURL   string `json:"apiURL"`
+	APIKey   string `json:"apiKey"`
+	ServerID string `json:"serverID"`
+	Zone     string `json:"zone"`
+}
+
+// powerDNSProvider manages challenge records using the PowerDNS
+// Authoritative Server HTTP API.
+type powerDNSProvider struct {
+	config powerDNSConfig
+	client *http.Client
+}
+
+type powerDNSZone struct {
+	Id     string          `json:"id"`
+	Name   string          `json:"name"`
+	RRsets []powerDNSRRset `json:"rrsets"`
+}
+
+type powerDNSRRset struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	TTL        int              `json:"ttl"`
+	ChangeType string           `json:"changetype,omitempty"`
+	Records    []powerDNSRecord `json:"records"`
+}
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+func newPowerDNSProvider(config []byte, _ *dnsClient) (dnsProvider, error) {
+	var c powerDNSConfig
+	err := json.Unmarshal(config, &c)
+	if err != nil {
+		return nil, errors.New("Error decoding powerdns config: " + err.Error())
+	}
+	if c.APIURL == "" || c.APIKey == "" {
+		return nil, errors.New("powerdns config requires an apiURL and an apiKey")
+	}
+	if c.ServerID == "" {
+		c.ServerID = "localhost"
+	}
+	return &powerDNSProvider{c, &httpClient}, nil
+}
+
+func (p *powerDNSProvider) createRecord(zone, fqdn, value string, ttl int) error {
+	pdnsZone, current, err := p.rrset(zone, fqdn)
+	if err != nil {
+		return err
+	}
+
+	content := `"` + value + `"`
+	rrset := powerDNSRRset{Name: fqdn, Type: "TXT", TTL: ttl, ChangeType: "REPLACE"}
+	if current != nil {
+		for _, record := range current.Records {
+			if record.Content == content {
+				return nil
+			}
+		}
+		rrset.Records = current.Records
+	}
+	rrset.Records = append(rrset.Records, powerDNSRecord{Content: content})
+	return p.patch(pdnsZone, rrset)
+}
+
+func (p *powerDNSProvider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	pdnsZone, current, err := p.rrset(zone, fqdn)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+
+	rrset := powerDNSRRset{Name: fqdn, Type: "TXT", TTL: current.TTL, ChangeType: "REPLACE"}
+	for _, record := range current.Records {
+		if record.Content != `"`+value+`"` {
+			rrset.Records = append(rrset.Records, record)
+		}
+	}
+	if len(rrset.Records) == len(current.Records) {
+		return nil
+	}
+	if len(rrset.Records) == 0 {
+		rrset.ChangeType = "DELETE"
+	}
+	return p.patch(pdnsZone, rrset)
+}
+
+// rrset returns the configured zone, the given zone or the zone containing
+// fqdn, and the existing TXT record set for fqdn, if any.
+func (p *powerDNSProvider) rrset(zone, fqdn string) (*powerDNSZone, *powerDNSRRset, error) {
+	zoneName := p.config.Zone
+	if zoneName == "" {
+		zoneName = zone
+	}
+	if zoneName == "" {
+		var zones []powerDNSZone
+		err := p.do("GET", "/zones", nil, &zones)
+		if err != nil {
+			return nil, nil, err
+		}
+		names := make([]string, 0)
+		for _, zone := range zones {
+			names = append(names, zone.Name)
+		}
+		var ok bool
+		zoneName, ok = matchZone(fqdn, names)
+		if !ok {
+			return nil, nil, fmt.Errorf("No PowerDNS zone found for %s", fqdn)
+		}
+	}
+
+	var pdnsZone powerDNSZone
+	err := p.do("GET", "/zones/"+powerDNSZoneID(zoneName), nil, &pdnsZone)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, rrset := range pdnsZone.RRsets {
+		if rrset.Type == "TXT" && strings.EqualFold(rrset.Name, fqdn) {
+			return &pdnsZone, &rrset, nil
+		}
+	}
+	return &pdnsZone, nil, nil
+}
+
+func (p *powerDNSProvider) patch(zone *powerDNSZone, rrset powerDNSRRset) error {
+	body := map[string][]powerDNSRRset{"rrsets": {rrset}}
+	return p.do("PATCH", "/zones/"+zone.Id, body, nil)
+}
+
+func (p *powerDNSProvider) do(method, path string, body, v interface{}) error {
+	u := strings.TrimRight(p.config.APIURL, "/") + "/api/v1/servers/" + p.config.ServerID + path
+
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	if body != nil {
+		err := json.NewEncoder(buf).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, u, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", p.config.APIKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("PowerDNS %s failed: %s", path, e.Error)
+		}
+		return fmt.Errorf("PowerDNS %s failed: %s", path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// powerDNSZoneID returns the PowerDNS zone ID for zone. Zone IDs are the
+// fully qualified zone name with '/' escaped.
+func powerDNSZoneID(zone string) string {
+	return strings.Replace(dns.Fqdn(zone), "/", "=2F", -1)
+}
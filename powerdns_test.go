@@ -0,0 +1,167 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// powerDNSServer is a stand-in for the PowerDNS Authoritative Server API of
+// the localhost server, applying patches to the record sets of its zones.
+type powerDNSServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	zones   map[string]*powerDNSZone
+	patches []powerDNSRRset
+}
+
+func newPowerDNSServer(t *testing.T, zones ...string) *powerDNSServer {
+	s := &powerDNSServer{zones: make(map[string]*powerDNSZone)}
+	for _, name := range zones {
+		s.zones[powerDNSZoneID(name)] = &powerDNSZone{Id: powerDNSZoneID(name), Name: name}
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Header.Get("X-API-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Unauthorized"}`))
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1/servers/localhost/zones")
+		zone := s.zones[strings.TrimPrefix(path, "/")]
+		switch {
+		case r.Method == "GET" && path == "":
+			list := make([]powerDNSZone, 0)
+			for _, zone := range s.zones {
+				list = append(list, powerDNSZone{Id: zone.Id, Name: zone.Name})
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == "GET" && zone != nil:
+			json.NewEncoder(w).Encode(zone)
+		case r.Method == "PATCH" && zone != nil:
+			var body struct {
+				RRsets []powerDNSRRset `json:"rrsets"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decoding patch: %s", err)
+			}
+			for _, rrset := range body.RRsets {
+				s.patches = append(s.patches, rrset)
+				rrsets := make([]powerDNSRRset, 0)
+				for _, current := range zone.RRsets {
+					if current.Name != rrset.Name || current.Type != rrset.Type {
+						rrsets = append(rrsets, current)
+					}
+				}
+				if rrset.ChangeType == "REPLACE" {
+					rrset.ChangeType = ""
+					rrsets = append(rrsets, rrset)
+				}
+				zone.RRsets = rrsets
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Not Found"}`))
+		}
+	}))
+	return s
+}
+
+func newTestPowerDNSProvider(t *testing.T, config string) dnsProvider {
+	p, err := newPowerDNSProvider([]byte(config), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPowerDNSCreateDeleteRecord(t *testing.T) {
+	s := newPowerDNSServer(t, "example.com.", "sub.example.com.")
+	defer s.Close()
+	p := newTestPowerDNSProvider(t, `{"apiURL":"`+s.URL+`/","apiKey":"key"}`)
+
+	fqdn := "_acme-challenge.www.sub.example.com."
+	if err := p.createRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.createRecord("sub.example.com.", fqdn, "second", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.createRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	want := []powerDNSRRset{{Name: fqdn, Type: "TXT", TTL: 30, Records: []powerDNSRecord{{Content: `"first"`}, {Content: `"second"`}}}}
+	if zone := s.zones["sub.example.com."]; !reflect.DeepEqual(zone.RRsets, want) {
+		t.Fatalf("record sets = %+v, want %+v", zone.RRsets, want)
+	}
+	if len(s.zones["example.com."].RRsets) != 0 {
+		t.Errorf("record sets = %+v, want none in the parent zone", s.zones["example.com."].RRsets)
+	}
+	if len(s.patches) != 2 {
+		t.Errorf("patches = %+v, want two patches", s.patches)
+	}
+
+	if err := p.deleteRecord("", fqdn, "first", 30); err != nil {
+		t.Fatal(err)
+	}
+	want[0].Records = want[0].Records[1:]
+	if zone := s.zones["sub.example.com."]; !reflect.DeepEqual(zone.RRsets, want) {
+		t.Fatalf("record sets = %+v, want %+v", zone.RRsets, want)
+	}
+	if err := p.deleteRecord("", fqdn, "second", 30); err != nil {
+		t.Fatal(err)
+	}
+	if zone := s.zones["sub.example.com."]; len(zone.RRsets) != 0 {
+		t.Errorf("record sets = %+v, want none", zone.RRsets)
+	}
+	if last := s.patches[len(s.patches)-1]; last.ChangeType != "DELETE" {
+		t.Errorf("last patch = %+v, want the deletion of the record set", last)
+	}
+}
+
+func TestPowerDNSZoneConfig(t *testing.T) {
+	s := newPowerDNSServer(t, "example.com.")
+	defer s.Close()
+	p := newTestPowerDNSProvider(t, `{"apiURL":"`+s.URL+`","apiKey":"key","zone":"example.com"}`)
+
+	if err := p.createRecord("sub.example.com.", "_acme-challenge.sub.example.com.", "value", 30); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.zones["example.com."].RRsets) != 1 {
+		t.Errorf("record sets = %+v, want a record set in the configured zone", s.zones["example.com."].RRsets)
+	}
+}
+
+func TestPowerDNSErrors(t *testing.T) {
+	s := newPowerDNSServer(t, "example.com.")
+	defer s.Close()
+
+	p := newTestPowerDNSProvider(t, `{"apiURL":"`+s.URL+`","apiKey":"key"}`)
+	err := p.createRecord("", "_acme-challenge.example.org.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "No PowerDNS zone found") {
+		t.Errorf("createRecord error = %v, want no zone found", err)
+	}
+
+	p = newTestPowerDNSProvider(t, `{"apiURL":"`+s.URL+`","apiKey":"wrong"}`)
+	err = p.createRecord("example.com.", "_acme-challenge.example.com.", "value", 30)
+	if err == nil || err.Error() != "PowerDNS /zones/example.com. failed: Unauthorized" {
+		t.Errorf("createRecord error = %v, want the PowerDNS error message", err)
+	}
+}
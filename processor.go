@@ -11,6 +11,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
@@ -31,7 +32,7 @@ func reconcileCertificates(interval int, db *bolt.DB, done chan struct{}, wg *sy
 		for {
 			select {
 			case <-time.After(time.Duration(interval) * time.Second):
-				err := syncCertificates(db)
+				_, err := syncCertificates(db)
 				if err != nil {
 					log.Println(err)
 				}
@@ -44,8 +45,8 @@ func reconcileCertificates(interval int, db *bolt.DB, done chan struct{}, wg *sy
 	}()
 }
 
-func watchCertificateEvents(db *bolt.DB, done chan struct{}, wg *sync.WaitGroup) {
-	events, watchErrs := monitorCertificateEvents()
+func watchCertificateEvents(db *bolt.DB, resourceVersion string, done chan struct{}, wg *sync.WaitGroup) {
+	events, watchErrs := monitorCertificateEvents(resourceVersion)
 	go func() {
 		for {
 			select {
@@ -65,13 +66,15 @@ func watchCertificateEvents(db *bolt.DB, done chan struct{}, wg *sync.WaitGroup)
 	}()
 }
 
-func syncCertificates(db *bolt.DB) error {
+// syncCertificates reconciles all Certificates and returns the
+// resourceVersion of the Certificate list.
+func syncCertificates(db *bolt.DB) (string, error) {
 	processorLock.Lock()
 	defer processorLock.Unlock()
 
-	certificates, err := getCertificates()
+	certificates, resourceVersion, err := getCertificates()
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var wg sync.WaitGroup
@@ -79,14 +82,14 @@ func syncCertificates(db *bolt.DB) error {
 		wg.Add(1)
 		go func(cert Certificate) {
 			defer wg.Done()
-			err := processCertificate(cert, db)
+			err := reconcileCertificate(cert, db)
 			if err != nil {
-				log.Println(err)
+				reportCertificateFailure(cert, err)
 			}
 		}(cert)
 	}
 	wg.Wait()
-	return nil
+	return resourceVersion, nil
 }
 
 func processCertificateEvent(c CertificateEvent, db *bolt.DB) error {
@@ -94,13 +97,233 @@ func processCertificateEvent(c CertificateEvent, db *bolt.DB) error {
 	defer processorLock.Unlock()
 	switch {
 	case c.Type == "ADDED":
-		return processCertificate(c.Object, db)
+		err := reconcileCertificate(c.Object, db)
+		if err != nil {
+			reportCertificateFailure(c.Object, err)
+		}
+		return nil
+	case c.Type == "MODIFIED":
+		// Certificates are modified without spec changes, for example when
+		// their metadata or status is updated, which requires no processing
+		// unless the Certificate is being deleted.
+		if c.Object.Metadata.DeletionTimestamp == "" {
+			changed, err := certificateSpecChanged(c.Object, db)
+			if err != nil || !changed {
+				return err
+			}
+		}
+		err := reconcileCertificate(c.Object, db)
+		if err != nil {
+			reportCertificateFailure(c.Object, err)
+		}
+		return nil
 	case c.Type == "DELETED":
-		return deleteCertificate(c.Object, db)
+		// Certificates are cleaned up by finalizeCertificate before they are
+		// deleted, unless the finalizer was removed by someone else.
+		state, err := findCertificateState(c.Object, db)
+		if err != nil || state == nil {
+			return err
+		}
+		err = deleteCertificate(c.Object, db)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		return deleteCertificateState(c.Object, db)
 	}
 	return nil
 }
 
+// certificateSpecChanged returns true if the spec of the Certificate changed
+// since it was last processed, or if it has not been processed before.
+func certificateSpecChanged(c Certificate, db *bolt.DB) (bool, error) {
+	state, err := findCertificateState(c, db)
+	if err != nil || state == nil {
+		return true, err
+	}
+	hash, err := specHash(c)
+	if err != nil {
+		return false, err
+	}
+	return state.SpecHash != hash, nil
+}
+
+// reconcileCertificate processes the Certificate and records the result in
+// the Certificate status. Deleted Certificates are finalized instead.
+func reconcileCertificate(c Certificate, db *bolt.DB) error {
+	if c.Metadata.DeletionTimestamp != "" {
+		return finalizeCertificate(c, db)
+	}
+
+	err := addFinalizer(c)
+	if err == nil {
+		err = reconcileCertificateSpec(c, db)
+	}
+	statusErr := updateCertificateStatus(c, err, db)
+	if statusErr != nil {
+		log.Printf("Error updating certificate %s/%s status: %s", c.Metadata.Namespace, c.Metadata.Name, statusErr)
+	}
+	return err
+}
+
+// reconcileCertificateSpec processes the Certificate after applying changes
+// to its spec since it was last processed. A changed spec forces a new
+// certificate to be issued, and a changed domain deletes the Secret and
+// account record of the old domain.
+func reconcileCertificateSpec(c Certificate, db *bolt.DB) error {
+	state, err := findCertificateState(c, db)
+	if err != nil {
+		return err
+	}
+	hash, err := specHash(c)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.SpecHash != hash {
+		if state != nil {
+			err := applySpecChange(c, state, db)
+			if err != nil {
+				return err
+			}
+		}
+		err := saveCertificateState(c, db)
+		if err != nil {
+			return errors.New("Error saving certificate state: " + err.Error())
+		}
+	}
+	return processCertificate(c, db)
+}
+
+func applySpecChange(c Certificate, state *CertificateState, db *bolt.DB) error {
+	if state.Domain != c.Spec.Domain {
+		log.Printf("%s/%s domain changed from %s to %s", c.Metadata.Namespace, c.Metadata.Name, state.Domain, c.Spec.Domain)
+		old := c
+		old.Spec.Domain = state.Domain
+		err := deleteCertificate(old, db)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	account, err := findAccount(c.Spec.Domain, db)
+	if err != nil || account == nil {
+		return err
+	}
+	if state.Email != c.Spec.Email || state.Issuer != issuerType(c) {
+		log.Printf("%s/%s account changed, deleting account: %s", c.Metadata.Namespace, c.Metadata.Name, c.Spec.Domain)
+		return deleteAccount(c.Spec.Domain, db)
+	}
+
+	log.Printf("%s/%s spec changed, reissuing certificate: %s", c.Metadata.Namespace, c.Metadata.Name, c.Spec.Domain)
+	account.Certificate = nil
+	account.CertificateURL = ""
+	account.CA = nil
+	return saveAccount(account, db)
+}
+
+// reportCertificateFailure logs why processing the Certificate failed,
+// including the captured output of failed plugins, and records a warning
+// event.
+func reportCertificateFailure(c Certificate, err error) {
+	log.Printf("Error processing certificate %s/%s: %s", c.Metadata.Namespace, c.Metadata.Name, err)
+	recorder.warning(c, failureReason(err), "%s", err)
+	if pluginErr, ok := err.(*pluginError); ok && len(pluginErr.Stdout) > 0 {
+		log.Printf("%s plugin output: %s", c.Metadata.Name, pluginErr.Stdout)
+	}
+}
+
+// dnsChallenge is the dns-01 challenge of a single Certificate identifier.
+type dnsChallenge struct {
+	authorization *acme.Authorization
+	challenge     *acme.Challenge
+	client        *dnsClient
+	record        *ChallengeRecord
+}
+
+// prepareDNSChallenge authorizes domain and creates its challenge record
+// using the solver selected for domain, or for the CNAME target of its
+// challenge record. The challenge is returned once its record has been
+// added to the ledger, even if creating the record fails.
+func prepareDNSChallenge(c Certificate, domain string, acmeClient *ACMEClient, account *Account, db *bolt.DB) (*dnsChallenge, error) {
+	solver, err := solverFor(c, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	authorization, challenge, err := acmeClient.Authorize(domain)
+	if err != nil {
+		return nil, errors.New("Error authorizing account: " + err.Error())
+	}
+
+	jwkThumbprint, err := acme.JWKThumbprint(&account.AccountKey.PublicKey)
+	if err != nil {
+		return nil, errors.New("Error generating the JWK thumbprint: " + err.Error())
+	}
+
+	fqdn, value, ttl := DNSChallengeRecord(domain, challenge.Token, jwkThumbprint)
+
+	// The challenge record may be delegated to another zone using a CNAME,
+	// in which case the TXT record is written at the end of the chain using
+	// the solver of the target zone. The solver of the identifier is kept
+	// unless a solver lists a zone containing the target.
+	if solver.FollowCNAME {
+		target, err := resolveCNAME(fqdn)
+		if err != nil {
+			return nil, errors.New("Error resolving challenge record CNAME: " + err.Error())
+		}
+		if target != fqdn {
+			log.Printf("Following %s CNAME to %s", fqdn, target)
+			fqdn = target
+			if targetSolver, n := matchSolver(c, target); n > 0 {
+				solver = targetSolver
+			}
+		}
+	}
+
+	zone, err := findZone(fqdn)
+	if err != nil {
+		return nil, errors.New("Error finding the challenge record zone: " + err.Error())
+	}
+
+	dnsExecClient := &dnsClient{
+		domain:          domain,
+		provider:        solver.Provider,
+		secret:          solver.Secret,
+		secretKey:       solver.SecretKey,
+		namespace:       c.Metadata.Namespace,
+		zone:            zone,
+		propagation:     propagationSettings(solver.Provider, solver.Propagation),
+		credentialsSpec: solver.Credentials,
+	}
+
+	record := &ChallengeRecord{
+		Owner:       c.Metadata.Namespace + "/" + c.Metadata.Name,
+		Domain:      domain,
+		Provider:    solver.Provider,
+		Secret:      solver.Secret,
+		SecretKey:   solver.SecretKey,
+		Credentials: solver.Credentials,
+		Namespace:   c.Metadata.Namespace,
+		Zone:        zone,
+		FQDN:        fqdn,
+		Value:       value,
+		TTL:         ttl,
+		Created:     time.Now(),
+	}
+	err = saveChallengeRecord(record, db)
+	if err != nil {
+		return nil, errors.New("Error saving challenge record: " + err.Error())
+	}
+
+	ch := &dnsChallenge{authorization, challenge, dnsExecClient, record}
+	err = dnsExecClient.createRecord(fqdn, value, ttl)
+	if err != nil {
+		return ch, err
+	}
+	recorder.normal(c, "ChallengeCreated", "Created dns-01 challenge record %s using %s", fqdn, solver.Provider)
+	return ch, nil
+}
+
 func deleteCertificate(c Certificate, db *bolt.DB) error {
 	log.Printf("Deleting Let's Encrypt account: %s", c.Spec.Domain)
 	err := deleteAccount(c.Spec.Domain, db)
@@ -112,6 +335,24 @@ func deleteCertificate(c Certificate, db *bolt.DB) error {
 }
 
 func processCertificate(c Certificate, db *bolt.DB) error {
+	if c.Spec.Issuer != nil && c.Spec.Issuer.Type != "acme" {
+		issuer, err := newIssuer(c)
+		if err != nil {
+			return err
+		}
+		return processIssuedCertificate(c, issuer, db)
+	}
+
+	for _, domain := range certificateDomains(c) {
+		solver, err := solverFor(c, domain)
+		if err != nil {
+			return err
+		}
+		if err := checkDNSProvider(solver.Provider); err != nil {
+			return err
+		}
+	}
+
 	account, err := findAccount(c.Spec.Domain, db)
 	if err != nil {
 		return err
@@ -144,6 +385,7 @@ func processCertificate(c Certificate, db *bolt.DB) error {
 		if err != nil {
 			return errors.New("Error saving account" + err.Error())
 		}
+		recorder.normal(c, "Registered", "Registered ACME account for %s", c.Spec.Email)
 	}
 
 	if account.CertificateURL != "" {
@@ -151,59 +393,74 @@ func processCertificate(c Certificate, db *bolt.DB) error {
 		if err != nil {
 			return errors.New("Error renewing certificate" + err.Error())
 		}
-		account.Certificate = cert
+		if !bytes.Equal(cert, account.Certificate) {
+			account.Certificate = cert
+			err = saveAccount(account, db)
+			if err != nil {
+				return errors.New("Error saving account" + err.Error())
+			}
+			recorder.normal(c, "Renewed", "Renewed certificate for %s: %s", c.Spec.Domain, describeCertificate(cert))
+		}
 		key := pem.EncodeToMemory(&pem.Block{
 			Type:    "RSA PRIVATE KEY",
 			Headers: nil,
 			Bytes:   x509.MarshalPKCS1PrivateKey(account.CertificateKey),
 		})
-		err = syncKubernetesSecret(c, account.Certificate, key)
+		err = syncKubernetesSecret(c, account.Certificate, key, nil)
 		if err != nil {
 			return errors.New("Error creating Kubernetes secret: " + err.Error())
 		}
 		return nil
 	}
 
-	authorization, challenge, err := acmeClient.Authorize(c.Spec.Domain)
+	log.Printf("Requesting %s certificate from the ACME server", c.Spec.Domain)
+	err = markCertificateIssuing(c, "Authorizing", "Solving dns-01 challenges")
 	if err != nil {
-		return errors.New("Error authorizing account: " + err.Error())
+		log.Printf("Error updating certificate %s/%s status: %s", c.Metadata.Namespace, c.Metadata.Name, err)
 	}
 
-	jwkThumbprint, err := acme.JWKThumbprint(&account.AccountKey.PublicKey)
-	if err != nil {
-		return errors.New("Error generating the JWK thumbprint: " + err.Error())
-	}
-
-	fqdn, value, ttl := DNSChallengeRecord(c.Spec.Domain, challenge.Token, jwkThumbprint)
-
-	dnsExecClient := &dnsClient{
-		c.Spec.Domain,
-		c.Spec.Provider,
-		c.Spec.Secret,
-		c.Spec.SecretKey,
-		c.Metadata.Namespace,
-	}
-
-	// Cleaning up the DNS challenge here creates a race between two processes
-	// managing DNS challenge records.
-	dnsExecClient.deleteRecord(fqdn, value, ttl)
+	// Every challenge record is added to the ledger before it is created so
+	// that it is cleaned up even if issuance fails or the controller exits.
+	challenges := make([]*dnsChallenge, 0)
+	defer func() {
+		for _, ch := range challenges {
+			err := cleanupChallengeRecord(ch.record, db)
+			if err != nil {
+				log.Printf("Error deleting challenge record %s, retrying at %s: %s",
+					ch.record.FQDN, ch.record.NextAttempt.Format(time.RFC3339), err)
+			}
+		}
+	}()
 
-	err = dnsExecClient.createRecord(fqdn, value, ttl)
-	if err != nil {
-		return err
+	for _, domain := range certificateDomains(c) {
+		ch, err := prepareDNSChallenge(c, domain, acmeClient, account, db)
+		if ch != nil {
+			challenges = append(challenges, ch)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	// We need to make sure the DNS challenge record has propagated across the
-	// authoritative nameservers for the fqdn before accepting the ACME challenge.
-	if err := dnsExecClient.monitorDNSPropagation(fqdn, value, ttl); err != nil {
-		return err
+	// We need to make sure the DNS challenge records have propagated across
+	// the authoritative nameservers before accepting the ACME challenges.
+	for _, ch := range challenges {
+		if !ch.client.propagation.skip {
+			recorder.normal(c, "WaitingForPropagation", "Waiting for %s to propagate to the nameservers of %s", ch.record.FQDN, ch.record.Zone)
+		}
+		err := ch.client.monitorDNSPropagation(ch.record.FQDN, ch.record.Value, ch.record.TTL)
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := acmeClient.Accept(authorization, challenge); err != nil {
-		return err
+	for _, ch := range challenges {
+		if err := acmeClient.Accept(ch.authorization, ch.challenge); err != nil {
+			return err
+		}
 	}
 
-	cert, certURL, err := acmeClient.CreateCert(c.Spec.Domain, account.CertificateKey)
+	cert, certURL, err := acmeClient.CreateCert(c.Spec.Domain, c.Spec.AltNames, account.CertificateKey)
 	if err != nil {
 		return err
 	}
@@ -214,20 +471,16 @@ func processCertificate(c Certificate, db *bolt.DB) error {
 	if err != nil {
 		return err
 	}
+	recorder.normal(c, "Issued", "Issued certificate for %s: %s", c.Spec.Domain, describeCertificate(cert))
 
 	key := pem.EncodeToMemory(&pem.Block{
 		Type:    "RSA PRIVATE KEY",
 		Headers: nil,
 		Bytes:   x509.MarshalPKCS1PrivateKey(account.CertificateKey),
 	})
-	err = syncKubernetesSecret(c, account.Certificate, key)
+	err = syncKubernetesSecret(c, account.Certificate, key, nil)
 	if err != nil {
 		return errors.New("Error creating Kubernetes secret: " + err.Error())
 	}
-
-	err = dnsExecClient.deleteRecord(fqdn, value, ttl)
-	if err != nil {
-		return err
-	}
 	return nil
 }
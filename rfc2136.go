@@ -0,0 +1,143 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	dnsProviders["rfc2136"] = newRFC2136Provider
+}
+
+// rfc2136Config is the provider secret format of the rfc2136 DNS provider.
+type rfc2136Config struct {
+	Nameserver    string `json:"nameserver"`
+	Zone          string `json:"zone"`
+	TSIGKeyName   string `json:"tsigKeyName"`
+	TSIGAlgorithm string `json:"tsigAlgorithm"`
+	TSIGSecret    string `json:"tsigSecret"`
+}
+
+// rfc2136Provider manages challenge records using RFC 2136 dynamic DNS
+// updates, optionally signed with a TSIG key.
+type rfc2136Provider struct {
+	config rfc2136Config
+}
+
+func newRFC2136Provider(config []byte, _ *dnsClient) (dnsProvider, error) {
+	var c rfc2136Config
+	err := json.Unmarshal(config, &c)
+	if err != nil {
+		return nil, errors.New("Error decoding rfc2136 config: " + err.Error())
+	}
+	if c.Nameserver == "" {
+		return nil, errors.New("rfc2136 config requires a nameserver")
+	}
+	if _, _, err := net.SplitHostPort(c.Nameserver); err != nil {
+		c.Nameserver = net.JoinHostPort(c.Nameserver, "53")
+	}
+	if c.TSIGKeyName != "" {
+		c.TSIGKeyName = dns.Fqdn(c.TSIGKeyName)
+		if c.TSIGAlgorithm == "" {
+			c.TSIGAlgorithm = dns.HmacSHA256
+		}
+		c.TSIGAlgorithm = dns.Fqdn(strings.ToLower(c.TSIGAlgorithm))
+	}
+	return &rfc2136Provider{c}, nil
+}
+
+func (p *rfc2136Provider) createRecord(zone, fqdn, value string, ttl int) error {
+	return p.update(zone, fqdn, value, ttl, true)
+}
+
+func (p *rfc2136Provider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	return p.update(zone, fqdn, value, ttl, false)
+}
+
+func (p *rfc2136Provider) update(zone, fqdn, value string, ttl int, insert bool) error {
+	if p.config.Zone != "" {
+		zone = p.config.Zone
+	}
+	if zone == "" {
+		var err error
+		zone, err = p.findZone(fqdn)
+		if err != nil {
+			return err
+		}
+	}
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   fqdn,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(ttl),
+		},
+		Txt: []string{value},
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	if insert {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+
+	in, _, err := p.exchange(m)
+	if err != nil {
+		return err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("DNS update for %s failed: %s", fqdn, dns.RcodeToString[in.Rcode])
+	}
+	return nil
+}
+
+// findZone returns the zone containing fqdn by querying the nameserver for
+// the SOA record of each parent domain.
+func (p *rfc2136Provider) findZone(fqdn string) (string, error) {
+	labels := dns.Split(fqdn)
+	for _, i := range labels {
+		name := fqdn[i:]
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeSOA)
+		in, _, err := p.exchange(m)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range in.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && soa.Hdr.Name == name {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("No zone found for %s on %s", fqdn, p.config.Nameserver)
+}
+
+func (p *rfc2136Provider) exchange(m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	client := new(dns.Client)
+	client.Net = "tcp"
+	client.Timeout = time.Second * 10
+	if p.config.TSIGKeyName != "" {
+		client.TsigSecret = map[string]string{p.config.TSIGKeyName: p.config.TSIGSecret}
+		m.SetTsig(p.config.TSIGKeyName, p.config.TSIGAlgorithm, 300, time.Now().Unix())
+	}
+	return client.Exchange(m, p.config.Nameserver)
+}
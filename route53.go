@@ -0,0 +1,254 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	dnsProviders["route53"] = newRoute53Provider
+}
+
+// route53Config is the provider secret format of the route53 DNS provider.
+type route53Config struct {
+	AccessKeyID     string `json:"accessKeyID"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+	HostedZoneID    string `json:"hostedZoneID"`
+	Endpoint        string `json:"endpoint"`
+}
+
+// route53Provider manages challenge records using the Amazon Route 53 API.
+type route53Provider struct {
+	config  route53Config
+	client  *http.Client
+	baseURL string
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action string                  `xml:"Action"`
+	Name   string                  `xml:"ResourceRecordSet>Name"`
+	Type   string                  `xml:"ResourceRecordSet>Type"`
+	TTL    int                     `xml:"ResourceRecordSet>TTL"`
+	Values []route53ResourceRecord `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord"`
+}
+
+type route53ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type route53HostedZones struct {
+	HostedZones []struct {
+		Id   string `xml:"Id"`
+		Name string `xml:"Name"`
+	} `xml:"HostedZones>HostedZone"`
+}
+
+type route53Error struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func newRoute53Provider(config []byte, _ *dnsClient) (dnsProvider, error) {
+	var c route53Config
+	err := json.Unmarshal(config, &c)
+	if err != nil {
+		return nil, errors.New("Error decoding route53 config: " + err.Error())
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, errors.New("route53 config requires an accessKeyID and a secretAccessKey")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://route53.amazonaws.com"
+	}
+	return &route53Provider{c, &httpClient, strings.TrimRight(c.Endpoint, "/")}, nil
+}
+
+func (p *route53Provider) createRecord(zone, fqdn, value string, ttl int) error {
+	return p.changeRecord("UPSERT", zone, fqdn, value, ttl)
+}
+
+func (p *route53Provider) deleteRecord(zone, fqdn, value string, ttl int) error {
+	return p.changeRecord("DELETE", zone, fqdn, value, ttl)
+}
+
+func (p *route53Provider) changeRecord(action, zone, fqdn, value string, ttl int) error {
+	zoneID, err := p.hostedZoneID(zone, fqdn)
+	if err != nil {
+		return err
+	}
+
+	body, err := xml.Marshal(route53ChangeRequest{
+		Changes: []route53Change{{
+			Action: action,
+			Name:   fqdn,
+			Type:   "TXT",
+			TTL:    ttl,
+			Values: []route53ResourceRecord{{Value: `"` + value + `"`}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.do("POST", "/2013-04-01/hostedzone/"+zoneID+"/rrset", nil, body, nil)
+}
+
+// hostedZoneID returns the configured hosted zone ID or the ID of the
+// hosted zone with the longest name containing zone. The zone of fqdn is
+// looked up if it is unknown, as Route 53 only lists hosted zones by name.
+func (p *route53Provider) hostedZoneID(zone, fqdn string) (string, error) {
+	if p.config.HostedZoneID != "" {
+		return p.config.HostedZoneID, nil
+	}
+	if zone == "" {
+		var err error
+		zone, err = findZone(fqdn)
+		if err != nil {
+			return "", errors.New("Error finding the zone of " + fqdn + ": " + err.Error())
+		}
+	}
+
+	var zones route53HostedZones
+	query := url.Values{"dnsname": {zone}}
+	err := p.do("GET", "/2013-04-01/hostedzonesbyname", query, nil, &zones)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0)
+	ids := make(map[string]string)
+	for _, zone := range zones.HostedZones {
+		names = append(names, zone.Name)
+		ids[zone.Name] = strings.TrimPrefix(zone.Id, "/hostedzone/")
+	}
+	match, ok := matchZone(zone, names)
+	if !ok {
+		return "", fmt.Errorf("No Route 53 hosted zone found for %s", fqdn)
+	}
+	return ids[match], nil
+}
+
+func (p *route53Provider) do(method, path string, query url.Values, body []byte, v interface{}) error {
+	u := p.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	signAWSRequest(req, body, p.config.AccessKeyID, p.config.SecretAccessKey, p.config.SessionToken, "us-east-1", "route53", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var e route53Error
+		if xml.NewDecoder(resp.Body).Decode(&e) == nil && e.Code != "" {
+			return fmt.Errorf("Route 53 %s failed: %s: %s", path, e.Code, e.Message)
+		}
+		return fmt.Errorf("Route 53 %s failed: %s", path, resp.Status)
+	}
+	if v == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// signAWSRequest signs req using AWS Signature Version 4.
+func signAWSRequest(req *http.Request, body []byte, accessKeyID, secretAccessKey, sessionToken, region, service string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headerNames := make([]string, 0)
+	for name := range req.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range headerNames {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, value)
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,162 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The signAWSRequest tests use the get-vanilla request of the AWS Signature
+// Version 4 test suite and the IAM ListUsers example of the AWS General
+// Reference.
+func TestSignAWSRequestVanilla(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "us-east-1", "service", date)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestSignAWSRequestQuery(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	date := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "us-east-1", "iam", date)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+// route53Server is a stand-in for the Route 53 API serving the given hosted
+// zones and recording change requests.
+type route53Server struct {
+	*httptest.Server
+	zones   string
+	changes map[string][]route53Change
+}
+
+func newRoute53Server(t *testing.T, zones string) *route53Server {
+	s := &route53Server{zones: zones, changes: make(map[string][]route53Change)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("%s %s: unexpected Authorization %q", r.Method, r.URL, r.Header.Get("Authorization"))
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/2013-04-01/hostedzonesbyname":
+			w.Write([]byte(s.zones))
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/2013-04-01/hostedzone/") && strings.HasSuffix(r.URL.Path, "/rrset"):
+			body, _ := ioutil.ReadAll(r.Body)
+			var request route53ChangeRequest
+			if err := xml.Unmarshal(body, &request); err != nil {
+				t.Errorf("decoding change request: %s", err)
+			}
+			zoneID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/2013-04-01/hostedzone/"), "/rrset")
+			s.changes[zoneID] = append(s.changes[zoneID], request.Changes...)
+			w.Write([]byte(`<ChangeResourceRecordSetsResponse/>`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<ErrorResponse><Error><Code>InvalidInput</Code><Message>unexpected request</Message></Error></ErrorResponse>`))
+		}
+	}))
+	return s
+}
+
+func newTestRoute53Provider(t *testing.T, config string) dnsProvider {
+	p, err := newRoute53Provider([]byte(config), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const route53TestZones = `<ListHostedZonesByNameResponse><HostedZones>
+<HostedZone><Id>/hostedzone/Z1</Id><Name>example.com.</Name></HostedZone>
+<HostedZone><Id>/hostedzone/Z2</Id><Name>sub.example.com.</Name></HostedZone>
+</HostedZones></ListHostedZonesByNameResponse>`
+
+func TestRoute53CreateDeleteRecord(t *testing.T) {
+	s := newRoute53Server(t, route53TestZones)
+	defer s.Close()
+	p := newTestRoute53Provider(t, `{"accessKeyID":"AKID","secretAccessKey":"secret","endpoint":"`+s.URL+`"}`)
+
+	fqdn := "_acme-challenge.www.sub.example.com."
+	if err := p.createRecord("sub.example.com.", fqdn, "value", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.deleteRecord("sub.example.com.", fqdn, "value", 30); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := s.changes["Z2"]
+	if len(changes) != 2 || len(s.changes) != 1 {
+		t.Fatalf("changes = %+v, want two changes to the Z2 hosted zone", s.changes)
+	}
+	for i, action := range []string{"UPSERT", "DELETE"} {
+		c := changes[i]
+		if c.Action != action || c.Name != fqdn || c.Type != "TXT" || c.TTL != 30 {
+			t.Errorf("change %d = %+v, want %s of the %s TXT record", i, c, action, fqdn)
+		}
+		if len(c.Values) != 1 || c.Values[0].Value != `"value"` {
+			t.Errorf("change %d values = %+v, want the quoted challenge value", i, c.Values)
+		}
+	}
+}
+
+func TestRoute53HostedZoneIDConfig(t *testing.T) {
+	s := newRoute53Server(t, "")
+	defer s.Close()
+	p := newTestRoute53Provider(t, `{"accessKeyID":"AKID","secretAccessKey":"secret","hostedZoneID":"Z9","endpoint":"`+s.URL+`"}`)
+
+	if err := p.createRecord("example.com.", "_acme-challenge.example.com.", "value", 30); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.changes["Z9"]) != 1 {
+		t.Errorf("changes = %+v, want a change to the configured Z9 hosted zone", s.changes)
+	}
+}
+
+func TestRoute53Errors(t *testing.T) {
+	s := newRoute53Server(t, `<ListHostedZonesByNameResponse/>`)
+	defer s.Close()
+	p := newTestRoute53Provider(t, `{"accessKeyID":"AKID","secretAccessKey":"secret","endpoint":"`+s.URL+`"}`)
+
+	err := p.createRecord("example.org.", "_acme-challenge.example.org.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "No Route 53 hosted zone found") {
+		t.Errorf("createRecord error = %v, want no hosted zone found", err)
+	}
+
+	p = newTestRoute53Provider(t, `{"accessKeyID":"AKID","secretAccessKey":"secret","hostedZoneID":"Z1","endpoint":"`+s.URL+`/invalid"}`)
+	err = p.createRecord("", "_acme-challenge.example.com.", "value", 30)
+	if err == nil || !strings.Contains(err.Error(), "InvalidInput: unexpected request") {
+		t.Errorf("createRecord error = %v, want the Route 53 error message", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// certificateSolvers returns the solvers of the Certificate. The top level
+// provider fields, if set, act as a solver matching every identifier.
+func certificateSolvers(c Certificate) []SolverSpec {
+	solvers := append([]SolverSpec(nil), c.Spec.Solvers...)
+	if c.Spec.Provider != "" {
+		solvers = append(solvers, SolverSpec{
+			Provider:    c.Spec.Provider,
+			Secret:      c.Spec.Secret,
+			SecretKey:   c.Spec.SecretKey,
+			Credentials: c.Spec.Credentials,
+			FollowCNAME: c.Spec.FollowCNAME,
+			Propagation: c.Spec.Propagation,
+		})
+	}
+	return solvers
+}
+
+// certificateDomains returns the identifiers of the Certificate.
+func certificateDomains(c Certificate) []string {
+	return append([]string{c.Spec.Domain}, c.Spec.AltNames...)
+}
+
+// solverFor returns the solver with the zone that is the longest suffix of
+// domain. Solvers without zones are used when no zone matches.
+func solverFor(c Certificate, domain string) (*SolverSpec, error) {
+	solver, _ := matchSolver(c, domain)
+	if solver == nil {
+		return nil, fmt.Errorf("No solver matches %s", domain)
+	}
+	return solver, nil
+}
+
+// matchSolver returns the solver for domain and the length of the matching
+// zone, which is 0 if a solver without zones was selected.
+func matchSolver(c Certificate, domain string) (*SolverSpec, int) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	var solver *SolverSpec
+	longest := -1
+	solvers := certificateSolvers(c)
+	for i := range solvers {
+		if len(solvers[i].Zones) == 0 && longest < 0 {
+			solver = &solvers[i]
+			longest = 0
+		}
+		for _, zone := range solvers[i].Zones {
+			zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+			if domain != zone && !strings.HasSuffix(domain, "."+zone) {
+				continue
+			}
+			if len(zone) > longest {
+				solver = &solvers[i]
+				longest = len(zone)
+			}
+		}
+	}
+	return solver, longest
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import "testing"
+
+func TestMatchSolver(t *testing.T) {
+	c := Certificate{Spec: CertificateSpec{Solvers: []SolverSpec{
+		{Zones: []string{"example.com"}, Provider: "googledns", FollowCNAME: true},
+		{Zones: []string{"acme.example.net.", "sub.example.com"}, Provider: "route53"},
+		{Provider: "cloudflare"},
+	}}}
+	tests := []struct {
+		domain   string
+		provider string
+		length   int
+	}{
+		{"www.example.com", "googledns", len("example.com")},
+		{"_acme-challenge.www.sub.example.com.", "route53", len("sub.example.com")},
+		{"www.example.com.acme.example.net.", "route53", len("acme.example.net")},
+		{"example.org", "cloudflare", 0},
+	}
+	for _, test := range tests {
+		solver, length := matchSolver(c, test.domain)
+		if solver == nil || solver.Provider != test.provider || length != test.length {
+			t.Errorf("matchSolver(%q) = %+v, %d, want the %s solver and %d", test.domain, solver, length, test.provider, test.length)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Certificate condition types.
+const (
+	conditionReady   = "Ready"
+	conditionIssuing = "Issuing"
+	conditionFailed  = "Failed"
+)
+
+// CertificateStatus is the status subresource of a Certificate object.
+// Times are formatted as RFC 3339.
+type CertificateStatus struct {
+	Conditions         []CertificateCondition `json:"conditions,omitempty"`
+	Serial             string                 `json:"serial,omitempty"`
+	NotBefore          string                 `json:"notBefore,omitempty"`
+	NotAfter           string                 `json:"notAfter,omitempty"`
+	RenewalTime        string                 `json:"renewalTime,omitempty"`
+	LastFailureMessage string                 `json:"lastFailureMessage,omitempty"`
+	LastFailureTime    string                 `json:"lastFailureTime,omitempty"`
+	ObservedGeneration int64                  `json:"observedGeneration,omitempty"`
+}
+
+type CertificateCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// setCondition sets the condition of type t. The transition time is only
+// changed when the condition status changes.
+func (s *CertificateStatus) setCondition(t, status, reason, message string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i := range s.Conditions {
+		c := &s.Conditions[i]
+		if c.Type != t {
+			continue
+		}
+		if c.Status != status {
+			c.LastTransitionTime = now
+		}
+		c.Status, c.Reason, c.Message = status, reason, message
+		return
+	}
+	s.Conditions = append(s.Conditions, CertificateCondition{t, status, reason, message, now})
+}
+
+// copyStatus returns a deep copy of the status of the Certificate.
+func copyStatus(c *Certificate) CertificateStatus {
+	if c.Status == nil {
+		return CertificateStatus{}
+	}
+	status := *c.Status
+	status.Conditions = append([]CertificateCondition(nil), c.Status.Conditions...)
+	return status
+}
+
+// markCertificateIssuing sets the Issuing condition of the Certificate
+// before a certificate is requested from the issuer.
+func markCertificateIssuing(c Certificate, reason, message string) error {
+	current, err := getCertificate(c.Metadata.Namespace, c.Metadata.Name)
+	if err != nil || current == nil {
+		return err
+	}
+	status := copyStatus(current)
+	status.setCondition(conditionIssuing, "True", reason, message)
+	return writeCertificateStatus(current, status)
+}
+
+// updateCertificateStatus records the result of processing the Certificate
+// and the details of the current certificate in the Certificate status.
+func updateCertificateStatus(c Certificate, processErr error, db *bolt.DB) error {
+	current, err := getCertificate(c.Metadata.Namespace, c.Metadata.Name)
+	if err != nil || current == nil {
+		return err
+	}
+	status := copyStatus(current)
+	status.ObservedGeneration = c.Metadata.Generation
+
+	account, err := findAccount(c.Spec.Domain, db)
+	if err != nil {
+		return err
+	}
+	var cert *x509.Certificate
+	if account != nil && account.Certificate != nil {
+		cert = parseLeafCertificate(account.Certificate)
+	}
+
+	status.Serial, status.NotBefore, status.NotAfter, status.RenewalTime = "", "", "", ""
+	if cert != nil {
+		lifetime := cert.NotAfter.Sub(cert.NotBefore)
+		status.Serial = fmt.Sprintf("%x", cert.SerialNumber)
+		status.NotBefore = cert.NotBefore.UTC().Format(time.RFC3339)
+		status.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+		status.RenewalTime = cert.NotAfter.Add(-lifetime / 3).UTC().Format(time.RFC3339)
+	}
+
+	switch {
+	case processErr != nil:
+		status.LastFailureMessage = processErr.Error()
+		status.LastFailureTime = time.Now().UTC().Format(time.RFC3339)
+		status.setCondition(conditionFailed, "True", failureReason(processErr), processErr.Error())
+		status.setCondition(conditionIssuing, "False", "Failed", "")
+	case cert == nil:
+		status.setCondition(conditionFailed, "False", "Pending", "")
+		status.setCondition(conditionIssuing, "True", "Pending", "Waiting for the issuer to sign the certificate")
+	default:
+		status.setCondition(conditionFailed, "False", "Issued", "")
+		status.setCondition(conditionIssuing, "False", "Issued", "")
+	}
+
+	switch {
+	case cert == nil:
+		status.setCondition(conditionReady, "False", "NotIssued", "No certificate has been issued")
+	case time.Now().After(cert.NotAfter):
+		status.setCondition(conditionReady, "False", "Expired", "Certificate expired at "+status.NotAfter)
+	default:
+		status.setCondition(conditionReady, "True", "Issued", "Certificate is valid until "+status.NotAfter)
+	}
+
+	return writeCertificateStatus(current, status)
+}
+
+// writeCertificateStatus updates the status of the Certificate if it
+// changed.
+func writeCertificateStatus(c *Certificate, status CertificateStatus) error {
+	if c.Status != nil && reflect.DeepEqual(*c.Status, status) {
+		return nil
+	}
+	c.Status = &status
+	return putCertificateStatus(c)
+}
+
+// failureReason returns the condition reason for the processing error.
+func failureReason(err error) string {
+	switch err.(type) {
+	case *pluginError:
+		return "PluginFailed"
+	case *apiError:
+		return "KubernetesAPIError"
+	}
+	return "ProcessingFailed"
+}
+
+// parseLeafCertificate returns the first certificate of the PEM encoded
+// chain, or nil if it cannot be parsed.
+func parseLeafCertificate(chain []byte) *x509.Certificate {
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type vaultIssuer struct {
+	client    *http.Client
+	spec      VaultIssuerSpec
+	domain    string
+	namespace string
+	// tokenFile is the service account token used to log in to Vault.
+	tokenFile string
+}
+
+type vaultResponse struct {
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Data *struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func newVaultIssuer(c Certificate) (*vaultIssuer, error) {
+	spec := c.Spec.Issuer.Vault
+	if spec == nil || spec.Address == "" || spec.Role == "" {
+		return nil, fmt.Errorf("Vault issuer for %s requires an address and a role", c.Metadata.Name)
+	}
+	if spec.KubernetesRole == "" && spec.TokenSecret == "" {
+		return nil, fmt.Errorf("Vault issuer for %s requires a kubernetesRole or a tokenSecret", c.Metadata.Name)
+	}
+
+	client, err := newHTTPClient(spec.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vaultIssuer{client, *spec, c.Spec.Domain, c.Metadata.Namespace, serviceAccountTokenFile}, nil
+}
+
+func (v *vaultIssuer) Sign(csr []byte) (*IssuedCertificate, error) {
+	token, err := v.token()
+	if err != nil {
+		return nil, errors.New("Error authenticating to Vault: " + err.Error())
+	}
+
+	path := v.spec.Path
+	if path == "" {
+		path = "pki"
+	}
+	request := map[string]string{
+		"csr":         string(csr),
+		"common_name": v.domain,
+		"format":      "pem",
+	}
+	if v.spec.TTL != "" {
+		request["ttl"] = v.spec.TTL
+	}
+
+	resp, err := v.do(path+"/sign/"+v.spec.Role, token, request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data == nil || resp.Data.Certificate == "" {
+		return nil, errors.New("Vault returned no certificate")
+	}
+
+	chain := resp.Data.CAChain
+	if len(chain) == 0 && resp.Data.IssuingCA != "" {
+		chain = []string{resp.Data.IssuingCA}
+	}
+
+	issued := &IssuedCertificate{
+		Certificate: []byte(strings.Join(append([]string{resp.Data.Certificate}, chain...), "\n") + "\n"),
+	}
+	if len(chain) > 0 {
+		issued.CA = []byte(chain[len(chain)-1] + "\n")
+	}
+	return issued, nil
+}
+
+// token returns a Vault token read from the configured Secret, or obtained by
+// logging in with the controller's Kubernetes service account.
+func (v *vaultIssuer) token() (string, error) {
+	if v.spec.TokenSecret != "" {
+		key := v.spec.TokenSecretKey
+		if key == "" {
+			key = "token"
+		}
+		token, err := getSecretValue(v.spec.TokenSecret, v.namespace, key)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(token)), nil
+	}
+
+	jwt, err := ioutil.ReadFile(v.tokenFile)
+	if err != nil {
+		return "", err
+	}
+	mountPath := v.spec.KubernetesMountPath
+	if mountPath == "" {
+		mountPath = "kubernetes"
+	}
+	resp, err := v.do("auth/"+mountPath+"/login", "", map[string]string{
+		"role": v.spec.KubernetesRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", errors.New("Vault login returned no client token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+func (v *vaultIssuer) do(path, token string, body interface{}) (*vaultResponse, error) {
+	b := make([]byte, 0)
+	buf := bytes.NewBuffer(b)
+	err := json.NewEncoder(buf).Encode(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(v.spec.Address, "/")+"/v1/"+path, buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Add("X-Vault-Token", token)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var vr vaultResponse
+	err = json.NewDecoder(resp.Body).Decode(&vr)
+	if err != nil && resp.StatusCode == 200 {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		if len(vr.Errors) > 0 {
+			return nil, fmt.Errorf("Vault %s failed: %s", path, strings.Join(vr.Errors, "; "))
+		}
+		return nil, fmt.Errorf("Vault %s failed: %s", path, resp.Status)
+	}
+	return &vr, nil
+}
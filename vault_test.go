@@ -0,0 +1,144 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newVaultServer is a stand-in for Vault with the kubernetes auth method
+// mounted at auth/kubernetes and a PKI secrets engine mounted at pki. It
+// signs CSRs for the web role when called with the given token.
+func newVaultServer(t *testing.T, token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/auth/kubernetes/login" && body["role"] == "kcm" && body["jwt"] == "jwt":
+			w.Write([]byte(`{"auth":{"client_token":"login-token"}}`))
+		case r.Method == "POST" && r.URL.Path == "/v1/pki/sign/web" && r.Header.Get("X-Vault-Token") == token:
+			if body["csr"] != "csr" || body["common_name"] != "www.example.com" || body["format"] != "pem" || body["ttl"] != "24h" {
+				t.Errorf("unexpected sign request %v", body)
+			}
+			w.Write([]byte(`{"data":{"certificate":"leaf","issuing_ca":"intermediate","ca_chain":["intermediate","root"]}}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+		}
+	}))
+}
+
+func newTestVaultIssuer(t *testing.T, spec VaultIssuerSpec) *vaultIssuer {
+	c := Certificate{
+		Metadata: Metadata{Name: "web", Namespace: "default"},
+		Spec:     CertificateSpec{Domain: "www.example.com", Issuer: &IssuerSpec{Type: "vault", Vault: &spec}},
+	}
+	v, err := newVaultIssuer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVaultSignWithTokenSecret(t *testing.T) {
+	vault := newVaultServer(t, "secret-token")
+	defer vault.Close()
+	kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/secrets/vault" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// "c2VjcmV0LXRva2VuCg==" is "secret-token\n".
+		w.Write([]byte(`{"metadata":{"name":"vault","namespace":"default"},"data":{"vault-token":"c2VjcmV0LXRva2VuCg=="}}`))
+	}))
+	defer kube.Close()
+	defer func(c *kubeClient) { kubeAPI = c }(kubeAPI)
+	kubeAPI = newProxyKubeClient()
+	kubeAPI.host = kube.URL
+
+	v := newTestVaultIssuer(t, VaultIssuerSpec{
+		Address:        vault.URL + "/",
+		Role:           "web",
+		TTL:            "24h",
+		TokenSecret:    "vault",
+		TokenSecretKey: "vault-token",
+	})
+	issued, err := v.Sign([]byte("csr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(issued.Certificate) != "leaf\nintermediate\nroot\n" {
+		t.Errorf("certificate = %q, want the leaf followed by the CA chain", issued.Certificate)
+	}
+	if string(issued.CA) != "root\n" {
+		t.Errorf("CA = %q, want the root of the CA chain", issued.CA)
+	}
+}
+
+func TestVaultSignWithKubernetesLogin(t *testing.T) {
+	vault := newVaultServer(t, "login-token")
+	defer vault.Close()
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTestVaultIssuer(t, VaultIssuerSpec{Address: vault.URL, Role: "web", TTL: "24h", KubernetesRole: "kcm"})
+	v.tokenFile = tokenFile
+	issued, err := v.Sign([]byte("csr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(issued.Certificate) != "leaf\nintermediate\nroot\n" {
+		t.Errorf("certificate = %q, want the leaf followed by the CA chain", issued.Certificate)
+	}
+
+	v.spec.KubernetesRole = "other"
+	_, err = v.Sign([]byte("csr"))
+	if err == nil || !strings.Contains(err.Error(), "Error authenticating to Vault: Vault auth/kubernetes/login failed: permission denied") {
+		t.Errorf("Sign error = %v, want the Vault login error", err)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	vault := newVaultServer(t, "login-token")
+	defer vault.Close()
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTestVaultIssuer(t, VaultIssuerSpec{Address: vault.URL, Path: "other", Role: "web", KubernetesRole: "kcm"})
+	v.tokenFile = tokenFile
+	_, err = v.Sign([]byte("csr"))
+	if err == nil || err.Error() != "Vault other/sign/web failed: permission denied" {
+		t.Errorf("Sign error = %v, want the Vault errors", err)
+	}
+}
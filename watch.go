@@ -0,0 +1,181 @@
+// Copyright 2016 Google Inc. All Rights Reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//     http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// watchEvent is a single event of a watch stream.
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// watchStatus is the Status object of an ERROR watch event.
+type watchStatus struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// errWatchExpired is reported when the resourceVersion of a watch is too old
+// and the resource is listed again. Objects deleted between the two versions
+// are not reported.
+type errWatchExpired struct {
+	Path string
+}
+
+func (e *errWatchExpired) Error() string {
+	return fmt.Sprintf("Watch of %s expired, listing again", e.Path)
+}
+
+// resumableWatch watches the resource at path from resourceVersion. If
+// resourceVersion is empty the resource is listed first and its objects are
+// sent as ADDED events. After a disconnect the watch is resumed from the
+// last seen resourceVersion. If that version is gone errWatchExpired is
+// reported and the objects of a new list are sent as MODIFIED events, so
+// changes missed in between are still seen. Events are decoded by a
+// separate goroutine and queued, so slow consumers do not stall the watch
+// stream.
+func resumableWatch(path, resourceVersion string) (<-chan watchEvent, <-chan error) {
+	events := make(chan watchEvent)
+	errc := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errc <- err:
+		default:
+			log.Println(err)
+		}
+	}
+	// Consumers must see every expiry to drop state derived from the
+	// missed events, so it is never dropped.
+	expired := func() {
+		resourceVersion = ""
+		errc <- &errWatchExpired{path}
+	}
+
+	go func() {
+		queue := make([]watchEvent, 0)
+		listEvent := "ADDED"
+		for {
+			if resourceVersion == "" {
+				var list struct {
+					Metadata Metadata          `json:"metadata"`
+					Items    []json.RawMessage `json:"items"`
+				}
+				err := kubeAPI.do("GET", path, nil, &list)
+				if err != nil {
+					report(err)
+					time.Sleep(5 * time.Second)
+					continue
+				}
+				for _, item := range list.Items {
+					queue = append(queue, watchEvent{Type: listEvent, Object: item})
+				}
+				resourceVersion = list.Metadata.ResourceVersion
+			}
+			listEvent = "MODIFIED"
+
+			query := url.Values{
+				"watch":               {"true"},
+				"allowWatchBookmarks": {"true"},
+				"resourceVersion":     {resourceVersion},
+			}
+			resp, err := kubeAPI.watch(path + "?" + query.Encode())
+			if e, ok := err.(*apiError); ok && e.StatusCode == http.StatusGone {
+				expired()
+				continue
+			}
+			if err != nil {
+				report(err)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			decoded := make(chan watchEvent)
+			decodeErr := make(chan error, 1)
+			go func() {
+				defer close(decoded)
+				decoder := json.NewDecoder(resp.Body)
+				for {
+					var event watchEvent
+					err := decoder.Decode(&event)
+					if err != nil {
+						decodeErr <- err
+						return
+					}
+					decoded <- event
+				}
+			}()
+
+		stream:
+			for {
+				var out chan watchEvent
+				var next watchEvent
+				if len(queue) > 0 {
+					out, next = events, queue[0]
+				}
+
+				select {
+				case event, ok := <-decoded:
+					if !ok {
+						break stream
+					}
+					switch event.Type {
+					case "ERROR":
+						var status watchStatus
+						json.Unmarshal(event.Object, &status)
+						if status.Code == http.StatusGone {
+							expired()
+						} else {
+							report(fmt.Errorf("Watch of %s failed: %s: %s", path, status.Reason, status.Message))
+						}
+						resp.Body.Close()
+					case "BOOKMARK":
+						resourceVersion = objectResourceVersion(event.Object, resourceVersion)
+					default:
+						resourceVersion = objectResourceVersion(event.Object, resourceVersion)
+						queue = append(queue, event)
+					}
+				case out <- next:
+					queue = queue[1:]
+				}
+			}
+			resp.Body.Close()
+
+			if err := <-decodeErr; err != io.EOF && resourceVersion != "" {
+				report(fmt.Errorf("Watch of %s interrupted: %s", path, err))
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+
+	return events, errc
+}
+
+// objectResourceVersion returns the resourceVersion of the object, or
+// current if the object has none.
+func objectResourceVersion(object json.RawMessage, current string) string {
+	var o struct {
+		Metadata Metadata `json:"metadata"`
+	}
+	if json.Unmarshal(object, &o) != nil || o.Metadata.ResourceVersion == "" {
+		return current
+	}
+	return o.Metadata.ResourceVersion
+}